@@ -0,0 +1,188 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	sdk "github.com/polynetwork/poly-go-sdk"
+	"github.com/polynetwork/polygon-relayer/config"
+	"github.com/polynetwork/polygon-relayer/heimdall"
+	sdkp "github.com/polynetwork/polygon-relayer/poly_go_sdk"
+	"github.com/polynetwork/polygon-relayer/tools"
+	"github.com/urfave/cli"
+)
+
+const CHECK_TIMEOUT = 10 * time.Second
+
+var (
+	ConfigCommand = cli.Command{
+		Name:  "config",
+		Usage: "Inspect the relayer config",
+		Subcommands: []cli.Command{
+			{
+				Name:   "check",
+				Usage:  "Validate the config and check every configured endpoint, without starting the relayer",
+				Flags:  []cli.Flag{ConfigPathFlag, ConfigSetFlag},
+				Action: checkConfig,
+			},
+		},
+	}
+)
+
+type configChecker struct {
+	failed int
+}
+
+func (this *configChecker) report(name string, err error, format string, a ...interface{}) {
+	if err != nil {
+		this.failed++
+		fmt.Printf("[FAIL] %s: %s\n", name, err)
+		return
+	}
+	fmt.Printf("[ OK ] %s: %s\n", name, fmt.Sprintf(format, a...))
+}
+
+func configPath(ctx *cli.Context) string {
+	name := GetFlagName(ConfigPathFlag)
+	if ctx.IsSet(name) {
+		return ctx.String(name)
+	}
+	return ctx.GlobalString(name)
+}
+
+func configOverrides(ctx *cli.Context) []string {
+	name := GetFlagName(ConfigSetFlag)
+	return append(ctx.GlobalStringSlice(name), ctx.StringSlice(name)...)
+}
+
+func checkConfig(ctx *cli.Context) error {
+	path := configPath(ctx)
+	servConfig, err := config.NewServiceConfig(path, configOverrides(ctx)...)
+	if err != nil {
+		fmt.Printf("[FAIL] %s\n", err)
+		return fmt.Errorf("config check failed")
+	}
+	fmt.Printf("[ OK ] %s is valid\n", path)
+
+	checker := &configChecker{}
+	checker.checkEth(servConfig)
+	checker.checkPoly(servConfig)
+	checker.checkHeimdall(servConfig)
+	checker.checkBridge(servConfig)
+
+	if checker.failed > 0 {
+		return fmt.Errorf("config check failed, %d problems found", checker.failed)
+	}
+	fmt.Println("config check passed")
+	return nil
+}
+
+func (this *configChecker) checkEth(servConfig *config.ServiceConfig) {
+	cfg := servConfig.ETHConfig
+	client, err := ethclient.Dial(cfg.RestURL)
+	if err != nil {
+		this.report("ETHConfig.RestURL", err, "")
+		return
+	}
+	defer client.Close()
+
+	c, cancel := context.WithTimeout(context.Background(), CHECK_TIMEOUT)
+	defer cancel()
+	chainId, err := client.ChainID(c)
+	if err != nil {
+		this.report("ETHConfig.RestURL", err, "")
+		return
+	}
+	height, err := tools.GetNodeHeight(cfg.RestURL, tools.NewRestClient())
+	this.report("ETHConfig.RestURL", err, "chain id %s, height %d", chainId.String(), height)
+
+	for name, addr := range map[string]string{
+		"ETHConfig.ECCMContractAddress": cfg.ECCMContractAddress,
+		"ETHConfig.ECCDContractAddress": cfg.ECCDContractAddress,
+	} {
+		code, err := client.CodeAt(c, common.HexToAddress(addr), nil)
+		if err == nil && len(code) == 0 {
+			err = fmt.Errorf("no contract code at %s", addr)
+		}
+		this.report(name, err, "%s has %d bytes of code", addr, len(code))
+	}
+
+	if cfg.SignerURL != "" {
+		signer, err := tools.NewRemoteEthSigner(cfg.SignerURL, chainId)
+		if err != nil {
+			this.report("ETHConfig.SignerURL", err, "")
+		} else {
+			this.report("ETHConfig.SignerURL", nil, "%d accounts", len(signer.GetAccounts()))
+		}
+	}
+}
+
+func (this *configChecker) checkPoly(servConfig *config.ServiceConfig) {
+	cfg := servConfig.PolyConfig
+	polySdk := sdk.NewPolySdk()
+	polySdk.NewRpcClient().SetAddress(cfg.RestURL)
+	height, err := polySdk.GetCurrentBlockHeight()
+	this.report("PolyConfig.RestURL", err, "height %d", height)
+
+	if cfg.SignerURL != "" {
+		signer, err := sdkp.NewRemoteSigner(cfg.SignerURL, cfg.SignerPublicKey)
+		if err == nil {
+			_, err = signer.Sign([]byte("config check"))
+		}
+		if err != nil {
+			this.report("PolyConfig.SignerURL", err, "")
+		} else {
+			addr := signer.GetAddress()
+			this.report("PolyConfig.SignerURL", nil, "account %s", addr.ToBase58())
+		}
+	}
+}
+
+func (this *configChecker) checkHeimdall(servConfig *config.ServiceConfig) {
+	client := heimdall.NewClient(servConfig.TendermintConfig.CosmosRpcAddr)
+	client.SetRetry(0, 0)
+	status, err := client.Status()
+	if err != nil {
+		this.report("TendermintConfig.CosmosRpcAddr", err, "")
+		return
+	}
+	this.report("TendermintConfig.CosmosRpcAddr", nil, "network %s, height %d",
+		status.NodeInfo.Network, status.SyncInfo.LatestBlockHeight)
+}
+
+func (this *configChecker) checkBridge(servConfig *config.ServiceConfig) {
+	client := &http.Client{Timeout: CHECK_TIMEOUT}
+	for i, urls := range servConfig.BridgeUrl {
+		for j, u := range urls {
+			name := fmt.Sprintf("BridgeUrl[%d][%d]", i, j)
+			// any http response means the bridge is reachable
+			resp, err := client.Get(u)
+			if err != nil {
+				this.report(name, err, "")
+				continue
+			}
+			resp.Body.Close()
+			this.report(name, nil, "%s reachable, http %d", u, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/polynetwork/polygon-relayer/config"
+	"github.com/polynetwork/polygon-relayer/db"
+	"github.com/polynetwork/polygon-relayer/manager"
+	"github.com/urfave/cli"
+)
+
+var (
+	DbPathFlag = cli.StringFlag{
+		Name:  "db",
+		Usage: "BoltDB `<path>`, BoltDbPath of the config if not set",
+	}
+
+	DeadLetterAllFlag = cli.BoolFlag{
+		Name:  "all",
+		Usage: "Replay every dead letter",
+	}
+
+	DeadLetterCommand = cli.Command{
+		Name:  "deadletter",
+		Usage: "Inspect and replay the transfers the relayer gave up on, the relayer must be stopped",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List the dead letters with the reason they were dropped",
+				Flags:  []cli.Flag{ConfigPathFlag, ConfigSetFlag, DbPathFlag},
+				Action: listDeadLetters,
+			},
+			{
+				Name:      "replay",
+				Usage:     "Put dead letters back to be relayed, poly transfers get their fee checked again",
+				ArgsUsage: "<direction:id>...",
+				Flags:     []cli.Flag{ConfigPathFlag, ConfigSetFlag, DbPathFlag, DeadLetterAllFlag},
+				Action:    replayDeadLetters,
+			},
+		},
+	}
+)
+
+func openDB(ctx *cli.Context) (*db.BoltDB, error) {
+	path := ctx.String(GetFlagName(DbPathFlag))
+	if path == "" {
+		servConfig, err := config.NewServiceConfig(configPath(ctx), configOverrides(ctx)...)
+		if err != nil {
+			return nil, err
+		}
+		path = servConfig.BoltDbPath
+	}
+	boltDB, err := db.NewBoltDB(path)
+	if err != nil {
+		return nil, fmt.Errorf("open db %s: %s, is the relayer running?", path, err)
+	}
+	return boltDB, nil
+}
+
+func listDeadLetters(ctx *cli.Context) error {
+	boltDB, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer boltDB.Close()
+
+	letters, err := boltDB.GetAllDeadLetters()
+	if err != nil {
+		return err
+	}
+	for _, v := range letters {
+		fmt.Printf("%s:%s\t%s\t%s\n", v.Direction, v.ID, time.Unix(v.Time, 0).UTC().Format(time.RFC3339), v.Reason)
+	}
+	fmt.Printf("%d dead letters\n", len(letters))
+	return nil
+}
+
+func replayDeadLetters(ctx *cli.Context) error {
+	ids := []string(ctx.Args())
+	all := ctx.Bool(GetFlagName(DeadLetterAllFlag))
+	if len(ids) == 0 && !all {
+		return fmt.Errorf("give the dead letters to replay as direction:id, or --all")
+	}
+	boltDB, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer boltDB.Close()
+
+	if all {
+		letters, err := boltDB.GetAllDeadLetters()
+		if err != nil {
+			return err
+		}
+		ids = ids[:0]
+		for _, v := range letters {
+			ids = append(ids, v.Direction+":"+v.ID)
+		}
+	}
+	failed := 0
+	for _, v := range ids {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			fmt.Printf("[FAIL] %s: not direction:id\n", v)
+			failed++
+			continue
+		}
+		if err := manager.ReplayDeadLetter(boltDB, parts[0], parts[1]); err != nil {
+			fmt.Printf("[FAIL] %s: %s\n", v, err)
+			failed++
+			continue
+		}
+		fmt.Printf("[ OK ] %s replayed\n", v)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dead letters not replayed", failed, len(ids))
+	}
+	return nil
+}
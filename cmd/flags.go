@@ -64,6 +64,11 @@ var (
 		Value: config.DEFAULT_CONFIG_FILE_NAME,
 	}
 
+	ConfigSetFlag = cli.StringSliceFlag{
+		Name:  "set",
+		Usage: "Override config field `<Section.Field=value>`, e.g. --set ETH.RestURL=http://127.0.0.1:8545, may be repeated",
+	}
+
 	EthStartFlag = cli.Uint64Flag{
 		Name:  "ethereum",
 		Usage: "eth start block height ",
@@ -0,0 +1,110 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/polynetwork/polygon-relayer/db"
+	"github.com/polynetwork/polygon-relayer/manager"
+	"github.com/urfave/cli"
+)
+
+var HeldCommand = cli.Command{
+	Name:  "held",
+	Usage: "Review the transfers held over the value caps, the relayer must be stopped",
+	Subcommands: []cli.Command{
+		{
+			Name:   "list",
+			Usage:  "List the held transfers with the cap they are over",
+			Flags:  []cli.Flag{ConfigPathFlag, ConfigSetFlag, DbPathFlag},
+			Action: listHeld,
+		},
+		{
+			Name:      "approve",
+			Usage:     "Relay held transfers past the caps",
+			ArgsUsage: "<direction:id>...",
+			Flags:     []cli.Flag{ConfigPathFlag, ConfigSetFlag, DbPathFlag},
+			Action: func(ctx *cli.Context) error {
+				return forEachHeld(ctx, "approved", manager.ApproveHeld)
+			},
+		},
+		{
+			Name:      "drop",
+			Usage:     "Move held transfers to the dead letters",
+			ArgsUsage: "<direction:id>...",
+			Flags:     []cli.Flag{ConfigPathFlag, ConfigSetFlag, DbPathFlag},
+			Action: func(ctx *cli.Context) error {
+				return forEachHeld(ctx, "dropped", manager.DropHeld)
+			},
+		},
+	},
+}
+
+func listHeld(ctx *cli.Context) error {
+	boltDB, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer boltDB.Close()
+
+	held, err := boltDB.GetAllHeld()
+	if err != nil {
+		return err
+	}
+	for _, v := range held {
+		fmt.Printf("%s:%s\t%s\t%s\n", v.Direction, v.ID, time.Unix(v.Time, 0).UTC().Format(time.RFC3339), v.Reason)
+	}
+	fmt.Printf("%d held transfers\n", len(held))
+	return nil
+}
+
+// forEachHeld applies action to every held transfer given, there is no --all
+// so that each one is reviewed
+func forEachHeld(ctx *cli.Context, done string, action func(boltDB *db.BoltDB, direction string, id string) error) error {
+	ids := []string(ctx.Args())
+	if len(ids) == 0 {
+		return fmt.Errorf("give the held transfers as direction:id")
+	}
+	boltDB, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer boltDB.Close()
+
+	failed := 0
+	for _, v := range ids {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			fmt.Printf("[FAIL] %s: not direction:id\n", v)
+			failed++
+			continue
+		}
+		if err := action(boltDB, parts[0], parts[1]); err != nil {
+			fmt.Printf("[FAIL] %s: %s\n", v, err)
+			failed++
+			continue
+		}
+		fmt.Printf("[ OK ] %s %s\n", v, done)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d held transfers not %s", failed, len(ids), done)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/polynetwork/polygon-relayer/config"
+	"github.com/urfave/cli"
+)
+
+var (
+	SecretsInFlag = cli.StringFlag{
+		Name:  "in",
+		Usage: "Input file `<path>`",
+	}
+
+	SecretsOutFlag = cli.StringFlag{
+		Name:  "out",
+		Usage: "Output file `<path>`",
+	}
+
+	SecretsCommand = cli.Command{
+		Name:  "secrets",
+		Usage: "Manage the encrypted secrets file, the master key is read from " + config.ENV_MASTER_KEY + " or " + config.ENV_MASTER_KEY_FILE,
+		Subcommands: []cli.Command{
+			{
+				Name:   "encrypt",
+				Usage:  "Encrypt a json object of secret name => password into a secrets file",
+				Flags:  []cli.Flag{SecretsInFlag, SecretsOutFlag},
+				Action: encryptSecrets,
+			},
+			{
+				Name:   "list",
+				Usage:  "List the secret names of a secrets file",
+				Flags:  []cli.Flag{SecretsInFlag},
+				Action: listSecrets,
+			},
+		},
+	}
+)
+
+func encryptSecrets(ctx *cli.Context) error {
+	in, out := ctx.String(GetFlagName(SecretsInFlag)), ctx.String(GetFlagName(SecretsOutFlag))
+	if in == "" || out == "" {
+		return fmt.Errorf("both --in and --out are required")
+	}
+	masterKey, err := config.MasterKey()
+	if err != nil {
+		return err
+	}
+	raw, err := config.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	secrets := make(map[string]string)
+	if err = json.Unmarshal(raw, &secrets); err != nil {
+		return fmt.Errorf("input must be a json object of secret name => password: %s", err)
+	}
+	enc, err := config.EncryptSecrets(secrets, masterKey)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(out, enc, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("%d secrets written to %s, refer to them as %sNAME in the config\n", len(secrets), out, config.SECRET_SECRET_PREFIX)
+	return nil
+}
+
+func listSecrets(ctx *cli.Context) error {
+	in := ctx.String(GetFlagName(SecretsInFlag))
+	if in == "" {
+		return fmt.Errorf("--in is required")
+	}
+	masterKey, err := config.MasterKey()
+	if err != nil {
+		return err
+	}
+	raw, err := config.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	secrets, err := config.DecryptSecrets(raw, masterKey)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(secrets))
+	for k := range secrets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, v := range names {
+		fmt.Println(v)
+	}
+	return nil
+}
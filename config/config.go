@@ -17,7 +17,6 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -38,6 +37,14 @@ const (
 	Version                  = "1.0"
 
 	DEFAULT_LOG_LEVEL = log.InfoLog
+
+	// ETHConfig.ProducerCheck, how bor headers are checked against the heimdall span producers
+	PRODUCER_CHECK_SIGNER = "signer" // signer is a producer of the span, the default
+	PRODUCER_CHECK_OFF    = "off"
+
+	// FeePolicyConfig.UnpaidAction and LowFeeAction
+	FEE_ACTION_DEFER  = "defer"
+	FEE_ACTION_REJECT = "reject"
 )
 
 type ServiceConfig struct {
@@ -47,7 +54,16 @@ type ServiceConfig struct {
 	BoltDbPath      string
 	RoutineNum      int64
 	TargetContracts []map[string]map[string][]uint64
-	BridgeUrl       [][]string
+	ContractsFile   string // allow and deny rules of contracts, reloaded on change, replaces TargetContracts
+	BridgeUrl       [][]string // groups of bridge urls, the next group is used when one fails
+	FeeCheckTTL     uint64     // seconds a fee check result is reused, default 30
+	TreasuryConfig  *TreasuryConfig
+	FeePolicy       *FeePolicyConfig // decides which paid transfers are relayed, optional
+	ValueCaps       *ValueCapsConfig // caps the value relayed through lock proxies, optional
+	SecretsFile     string // encrypted secrets file, unlocked by RELAYER_MASTER_KEY or RELAYER_MASTER_KEY_FILE
+	AdminAddr       string // host:port serving metrics at /debug/vars, disabled if empty
+	AdminToken      string // bearer token of the admin writes, e.g. PUT /contracts, refused if empty
+	AlertWebhook    string // url every alert is posted to as json, optional
 }
 
 type PolyConfig struct {
@@ -55,6 +71,8 @@ type PolyConfig struct {
 	EntranceContractAddress string
 	WalletFile              string
 	WalletPwd               string
+	SignerURL               string // remote signer, WalletFile and WalletPwd are not used when set
+	SignerPublicKey         string // hex serialized public key of the remote signer account
 }
 
 type ETHConfig struct {
@@ -65,9 +83,59 @@ type ETHConfig struct {
 	ECCDContractAddress string
 	KeyStorePath        string
 	KeyStorePwdSet      map[string]string
+	SignerURL           string // remote signer, KeyStorePath and KeyStorePwdSet are not used when set
 	BlockConfig         uint64
 	HeadersPerBatch     int
 	MonitorInterval     uint64
+	ProducerCheck       string // signer or off, see PRODUCER_CHECK_*
+}
+
+// TreasuryConfig is optional, when set the relayer tops up its bor senders from
+// the treasury account. All amounts are in wei.
+type TreasuryConfig struct {
+	KeyStorePath    string
+	KeyStorePwd     string
+	Address         string
+	FloorBalance    string // top up a sender once its balance drops below this
+	TargetBalance   string // balance a sender is topped up to
+	DailyCap        string // max amount sent by the treasury per UTC day
+	MaxTopUpsPerDay int    // max top-ups of one sender per UTC day, 0 means no limit
+	CheckInterval   uint64 // seconds
+}
+
+// FeePolicyConfig is optional, without it every transfer whose fee is paid is
+// relayed. Fees are in the unit the bridge reports them in.
+type FeePolicyConfig struct {
+	// minimum fee by "fromChainId:toContract", "fromChainId" or "*", the most
+	// specific one applies
+	MinFee        map[string]string
+	FreeContracts []string // bor target contracts relayed without fee
+	FreeSources   []string // source contracts relayed without fee, as "fromChainId:hexAddress"
+	// when set, the fee must cover GasCostRatio times the estimated bor gas cost
+	// of the transfer, with MaticPrice the fee of 1 MATIC
+	GasCostRatio  float64
+	MaticPrice    string
+	UnpaidAction  string // defer or reject a transfer whose fee is not paid, default reject
+	LowFeeAction  string // defer or reject a transfer paying too little, default defer
+	DeferInterval uint64 // seconds before the fee of a deferred transfer is checked again
+	MaxDefer      uint64 // seconds a transfer may stay deferred before it is rejected, 0 means no limit
+}
+
+// ValueCapsConfig is optional, it caps the value of the transfers of lock
+// proxies. Transfers over a cap are held until they are approved. Amounts are
+// in the smallest unit of the asset.
+type ValueCapsConfig struct {
+	LockProxies []string // bor lock proxies whose transfers are decoded and capped
+	// caps by "chainId:asset", the asset named in the lock proxy args: the bor
+	// asset of transfers to bor, the target chain asset of transfers from bor.
+	// "*" caps every other asset.
+	Assets map[string]*AssetCap
+}
+
+type AssetCap struct {
+	MaxPerTx     string // largest transfer, no cap if empty
+	MaxPerWindow string // most value transferred in Window, no cap if empty
+	Window       uint64 // seconds, default 86400
 }
 
 type TendermintConfig struct {
@@ -78,10 +146,13 @@ type TendermintConfig struct {
 	CosmosStartHeight    int64  
 	HeadersPerBatch      int
 	CosmosListenInterval int    
+	FastForwardGap       int64 // bisect for epoch switching heights when further behind, -1 disables
 
 	PolyRpcAddr        string 
 	PolyWallet         string 
 	PolyWalletPwd      string   
+	PolySignerURL       string
+	PolySignerPublicKey string
 
 	SideChainId    uint64 
 
@@ -106,27 +177,45 @@ func ReadFile(fileName string) ([]byte, error) {
 	return data, nil
 }
 
-func NewServiceConfig(configFilePath string) *ServiceConfig {
+// NewServiceConfig loads a json, yaml or toml config, applies the RELAYER_*
+// environment variables and then overrides, each a Section.Field=value string.
+func NewServiceConfig(configFilePath string, overrides ...string) (*ServiceConfig, error) {
 	fileContent, err := ReadFile(configFilePath)
 	if err != nil {
-		log.Errorf("NewServiceConfig: failed, err: %s", err)
-		return nil
+		return nil, fmt.Errorf("NewServiceConfig: %s", err)
 	}
 	servConfig := &ServiceConfig{}
-	err = json.Unmarshal(fileContent, servConfig)
+	err = decodeConfig(configFilePath, fileContent, servConfig)
 	if err != nil {
-		log.Errorf("NewServiceConfig: failed, err: %s", err)
-		return nil
+		return nil, fmt.Errorf("NewServiceConfig: failed to parse %s: %s", configFilePath, err)
+	}
+	if err = servConfig.applyEnv(); err != nil {
+		return nil, fmt.Errorf("NewServiceConfig: %s", err)
+	}
+	for _, v := range overrides {
+		if err = servConfig.ApplyOverride(v); err != nil {
+			return nil, fmt.Errorf("NewServiceConfig: %s", err)
+		}
+	}
+	servConfig.SetDefaults()
+	if err = servConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if err = servConfig.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("NewServiceConfig: failed to resolve secrets: %s", err)
 	}
 
 	servConfig.TendermintConfig.PolyRpcAddr = servConfig.PolyConfig.RestURL
 	servConfig.TendermintConfig.PolyWallet = servConfig.PolyConfig.WalletFile
 	servConfig.TendermintConfig.PolyWalletPwd = servConfig.PolyConfig.WalletPwd
+	servConfig.TendermintConfig.PolySignerURL = servConfig.PolyConfig.SignerURL
+	servConfig.TendermintConfig.PolySignerPublicKey = servConfig.PolyConfig.SignerPublicKey
 
+	pwdSet := make(map[string]string)
 	for k, v := range servConfig.ETHConfig.KeyStorePwdSet {
-		delete(servConfig.ETHConfig.KeyStorePwdSet, k)
-		servConfig.ETHConfig.KeyStorePwdSet[strings.ToLower(k)] = v
+		pwdSet[strings.ToLower(k)] = v
 	}
+	servConfig.ETHConfig.KeyStorePwdSet = pwdSet
 
-	return servConfig
+	return servConfig, nil
 }
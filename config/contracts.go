@@ -0,0 +1,221 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	CONTRACT_ANY              = "*" // any contract, or any chain id
+	DEFAULT_RATE_LIMIT_WINDOW = 3600
+)
+
+// ContractRules are the bor contracts the relayer relays transfers of, read
+// from ContractsFile or built from TargetContracts. A transfer is relayed if
+// no deny rule matches it and an allow rule does, or there is no allow rule.
+type ContractRules struct {
+	Allow []*ContractRule
+	Deny  []*ContractRule
+}
+
+// ContractRule matches the transfers of a bor contract, in either direction:
+// inbound transfers come to the contract from a chain, outbound ones go from
+// it to a chain. Contracts match whatever their case.
+type ContractRule struct {
+	Contract   string    // hex address, or * for any contract
+	Inbound    []ChainId // chains inbound transfers come from, * for any, none if empty
+	Outbound   []ChainId // chains outbound transfers go to, * for any, none if empty
+	RateLimit  int       // transfers per RateWindow in each direction, 0 means no limit, allow rules only
+	RateWindow uint64    // seconds, default 3600
+}
+
+// ChainId is a decimal chain id or *, json numbers are accepted
+type ChainId string
+
+func (this *ChainId) UnmarshalJSON(data []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err == nil {
+		*this = ChainId(n.String())
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("chain id %s is neither a number nor a string", string(data))
+	}
+	*this = ChainId(s)
+	return nil
+}
+
+// Uint64 returns the chain id, false for *
+func (this ChainId) Uint64() (uint64, bool) {
+	if this == CONTRACT_ANY {
+		return 0, false
+	}
+	id, _ := strconv.ParseUint(string(this), 10, 64)
+	return id, true
+}
+
+// LoadContractRules reads a json, yaml or toml rules file and validates it
+func LoadContractRules(path string) (*ContractRules, error) {
+	content, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &ContractRules{}
+	if err = decodeConfig(path, content, rules); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	rules.SetDefaults()
+	if err = rules.Validate(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// SaveContractRules replaces the rules file with rules, written in the format
+// of its extension
+func SaveContractRules(path string, rules *ContractRules) error {
+	content, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// go through json for the field names
+		var raw map[string]interface{}
+		if err = json.Unmarshal(content, &raw); err != nil {
+			return err
+		}
+		if content, err = yaml.Marshal(raw); err != nil {
+			return err
+		}
+	case ".toml":
+		buf := new(bytes.Buffer)
+		if err = toml.NewEncoder(buf).Encode(rules); err != nil {
+			return err
+		}
+		content = buf.Bytes()
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ContractRulesOf returns the rules of TargetContracts: every contract listed
+// is allowed, from or to any chain when no chain is listed for a direction.
+func ContractRulesOf(targets []map[string]map[string][]uint64) *ContractRules {
+	rules := &ContractRules{Allow: make([]*ContractRule, 0), Deny: make([]*ContractRule, 0)}
+	for _, v := range targets {
+		for addr, dirs := range v {
+			rules.Allow = append(rules.Allow, &ContractRule{
+				Contract: addr,
+				Inbound:  legacyChainIds(dirs[TARGET_INBOUND]),
+				Outbound: legacyChainIds(dirs[TARGET_OUTBOUND]),
+			})
+		}
+	}
+	return rules
+}
+
+func legacyChainIds(ids []uint64) []ChainId {
+	if len(ids) == 0 {
+		return []ChainId{CONTRACT_ANY}
+	}
+	res := make([]ChainId, len(ids))
+	for i, id := range ids {
+		res[i] = ChainId(strconv.FormatUint(id, 10))
+	}
+	return res
+}
+
+func (this *ContractRules) SetDefaults() {
+	for _, v := range this.Allow {
+		if v.RateLimit > 0 && v.RateWindow == 0 {
+			v.RateWindow = DEFAULT_RATE_LIMIT_WINDOW
+		}
+	}
+}
+
+// Validate returns a *ValidationError listing every problem found
+func (this *ContractRules) Validate() error {
+	verr := &ValidationError{}
+	for i, v := range this.Allow {
+		v.validate(verr, fmt.Sprintf("Allow[%d]", i))
+	}
+	for i, v := range this.Deny {
+		name := fmt.Sprintf("Deny[%d]", i)
+		v.validate(verr, name)
+		if v.RateLimit != 0 {
+			verr.add("%s: RateLimit is for allow rules only", name)
+		}
+	}
+	if len(verr.Errs) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func (this *ContractRule) validate(verr *ValidationError, name string) {
+	if this.Contract != CONTRACT_ANY {
+		checkAddress(verr, name+".Contract", this.Contract)
+	}
+	for dir, ids := range map[string][]ChainId{"Inbound": this.Inbound, "Outbound": this.Outbound} {
+		for _, id := range ids {
+			if id == CONTRACT_ANY {
+				continue
+			}
+			if _, err := strconv.ParseUint(string(id), 10, 64); err != nil {
+				verr.add("%s.%s: %s is not a chain id or %s", name, dir, id, CONTRACT_ANY)
+			}
+		}
+	}
+	if this.RateLimit < 0 {
+		verr.add("%s.RateLimit must not be negative, got %d", name, this.RateLimit)
+	}
+}
+
+// Matches returns whether the rule covers a transfer in direction
+// TARGET_INBOUND or TARGET_OUTBOUND of contract from or to chainId
+func (this *ContractRule) Matches(direction string, contract common.Address, chainId uint64) bool {
+	if this.Contract != CONTRACT_ANY && common.HexToAddress(this.Contract) != contract {
+		return false
+	}
+	ids := this.Inbound
+	if direction == TARGET_OUTBOUND {
+		ids = this.Outbound
+	}
+	for _, id := range ids {
+		if v, ok := id.Uint64(); !ok || v == chainId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Every config field can be overridden by an environment variable named
+// RELAYER_<SECTION>_<FIELD>, or RELAYER_<FIELD> for top level fields, where
+// SECTION is the section name without its Config suffix, e.g.
+// RELAYER_ETH_RESTURL or RELAYER_BOLTDBPATH. Maps and lists take json values.
+const ENV_OVERRIDE_PREFIX = "RELAYER_"
+
+// decodeConfig decodes a json, yaml or toml config, chosen by the file
+// extension. yaml and toml use the same field names as json.
+func decodeConfig(configFilePath string, content []byte, out interface{}) error {
+	var raw interface{}
+	switch strings.ToLower(filepath.Ext(configFilePath)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &raw); err != nil {
+			return err
+		}
+	case ".toml":
+		m := make(map[string]interface{})
+		if _, err := toml.Decode(string(content), &m); err != nil {
+			return err
+		}
+		raw = m
+	default:
+		return json.Unmarshal(content, out)
+	}
+	content, err := json.Marshal(jsonCompatible(raw))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, out)
+}
+
+// jsonCompatible converts the map[interface{}]interface{} produced by yaml
+// and the []map[string]interface{} produced by toml into types json can marshal.
+func jsonCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = jsonCompatible(v)
+		}
+		return m
+	case map[string]interface{}:
+		for k, v := range t {
+			t[k] = jsonCompatible(v)
+		}
+		return t
+	case []interface{}:
+		for i, v := range t {
+			t[i] = jsonCompatible(v)
+		}
+		return t
+	case []map[string]interface{}:
+		l := make([]interface{}, len(t))
+		for i, v := range t {
+			l[i] = jsonCompatible(v)
+		}
+		return l
+	}
+	return v
+}
+
+// configField is a settable field of the config, named Section.Field or Field
+type configField struct {
+	name  string
+	value reflect.Value
+}
+
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct
+}
+
+// fieldNames lists every field except the sections themselves
+func fieldNames() []string {
+	var res []string
+	t := reflect.TypeOf(ServiceConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !isSection(sf.Type) {
+			res = append(res, sf.Name)
+			continue
+		}
+		for j := 0; j < sf.Type.Elem().NumField(); j++ {
+			res = append(res, sf.Name+"."+sf.Type.Elem().Field(j).Name)
+		}
+	}
+	return res
+}
+
+// field looks a field up by name. Names are case insensitive and the Config
+// suffix of the section may be left out. A nil section is allocated.
+func (this *ServiceConfig) field(name string) (*configField, error) {
+	for _, v := range fieldNames() {
+		lower := strings.ToLower(v)
+		key := strings.ToLower(strings.TrimSpace(name))
+		if key != lower && key != strings.Replace(lower, "config.", ".", 1) {
+			continue
+		}
+		parts := strings.Split(v, ".")
+		f := reflect.ValueOf(this).Elem().FieldByName(parts[0])
+		if len(parts) == 2 {
+			if f.IsNil() {
+				f.Set(reflect.New(f.Type().Elem()))
+			}
+			f = f.Elem().FieldByName(parts[1])
+		}
+		return &configField{v, f}, nil
+	}
+	return nil, fmt.Errorf("unknown config field %s", name)
+}
+
+// envName returns the override environment variable of a field
+func envName(field string) string {
+	name := strings.Replace(field, "Config.", ".", 1)
+	return ENV_OVERRIDE_PREFIX + strings.ToUpper(strings.Replace(name, ".", "_", 1))
+}
+
+func setField(field *configField, value string) error {
+	v := field.value
+	var err error
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(value); err == nil {
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(value, 10, v.Type().Bits()); err == nil {
+			v.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(value, 10, v.Type().Bits()); err == nil {
+			v.SetUint(n)
+		}
+	default:
+		p := reflect.New(v.Type())
+		if err = json.Unmarshal([]byte(value), p.Interface()); err == nil {
+			v.Set(p.Elem())
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: %s", value, field.name, err)
+	}
+	return nil
+}
+
+// applyEnv overrides the config by the RELAYER_* environment variables
+func (this *ServiceConfig) applyEnv() error {
+	for _, name := range fieldNames() {
+		value, ok := os.LookupEnv(envName(name))
+		if !ok {
+			continue
+		}
+		field, err := this.field(name)
+		if err == nil {
+			err = setField(field, value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", envName(name), err)
+		}
+	}
+	return nil
+}
+
+// ApplyOverride sets one field from a Section.Field=value string, as given to
+// the --set flag, e.g. eth.resturl=http://127.0.0.1:8545
+func (this *ServiceConfig) ApplyOverride(override string) error {
+	kv := strings.SplitN(override, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("invalid override %q, expect Section.Field=value", override)
+	}
+	field, err := this.field(kv[0])
+	if err != nil {
+		return err
+	}
+	return setField(field, kv[1])
+}
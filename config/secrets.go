@@ -0,0 +1,237 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/polynetwork/polygon-relayer/log"
+	"golang.org/x/crypto/scrypt"
+)
+
+// A password in the config is either plaintext or a reference:
+//   env:NAME    read from environment variable NAME
+//   file:PATH   read from file PATH, e.g. a docker or kubernetes secret
+//   secret:NAME read entry NAME of the encrypted SecretsFile
+const (
+	SECRET_ENV_PREFIX    = "env:"
+	SECRET_FILE_PREFIX   = "file:"
+	SECRET_SECRET_PREFIX = "secret:"
+
+	ENV_MASTER_KEY      = "RELAYER_MASTER_KEY"
+	ENV_MASTER_KEY_FILE = "RELAYER_MASTER_KEY_FILE"
+	ENV_POLY_WALLET_PWD = "RELAYER_POLY_WALLET_PWD"
+	ENV_KEYSTORE_PWD    = "RELAYER_KEYSTORE_PWD"
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// SecretsFile is the content of the encrypted secrets file. Data is the
+// AES-256-GCM sealed json object of secret name => value, keyed by scrypt of
+// the master key.
+type SecretsFile struct {
+	Salt  []byte
+	Nonce []byte
+	Data  []byte
+}
+
+func deriveKey(masterKey []byte, salt []byte) ([]byte, error) {
+	return scrypt.Key(masterKey, salt, scryptN, scryptR, scryptP, 32)
+}
+
+func EncryptSecrets(secrets map[string]string, masterKey []byte) ([]byte, error) {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	file := &SecretsFile{
+		Salt: make([]byte, 32),
+	}
+	if _, err = rand.Read(file.Salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(masterKey, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(file.Nonce); err != nil {
+		return nil, err
+	}
+	file.Data = gcm.Seal(nil, file.Nonce, plain, nil)
+	return json.MarshalIndent(file, "", "  ")
+}
+
+func DecryptSecrets(raw []byte, masterKey []byte) (map[string]string, error) {
+	file := &SecretsFile{}
+	if err := json.Unmarshal(raw, file); err != nil {
+		return nil, fmt.Errorf("DecryptSecrets: invalid secrets file: %s", err)
+	}
+	key, err := deriveKey(masterKey, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("DecryptSecrets: invalid nonce size %d", len(file.Nonce))
+	}
+	plain, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("DecryptSecrets: wrong master key or corrupted file")
+	}
+	secrets := make(map[string]string)
+	if err = json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("DecryptSecrets: invalid secrets: %s", err)
+	}
+	return secrets, nil
+}
+
+// MasterKey reads the master key from RELAYER_MASTER_KEY or the file named by
+// RELAYER_MASTER_KEY_FILE.
+func MasterKey() ([]byte, error) {
+	if key := os.Getenv(ENV_MASTER_KEY); key != "" {
+		return []byte(key), nil
+	}
+	if path := os.Getenv(ENV_MASTER_KEY_FILE); path != "" {
+		raw, err := ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(raw), "\r\n")), nil
+	}
+	return nil, fmt.Errorf("MasterKey: neither %s nor %s is set", ENV_MASTER_KEY, ENV_MASTER_KEY_FILE)
+}
+
+type secretResolver struct {
+	secretsFile string
+	secrets     map[string]string
+}
+
+// resolve returns the password value refers to. name is only used in messages.
+func (this *secretResolver) resolve(name string, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, SECRET_ENV_PREFIX):
+		env := strings.TrimPrefix(value, SECRET_ENV_PREFIX)
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("%s: environment variable %s is not set", name, env)
+		}
+		return v, nil
+	case strings.HasPrefix(value, SECRET_FILE_PREFIX):
+		raw, err := ReadFile(strings.TrimPrefix(value, SECRET_FILE_PREFIX))
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", name, err)
+		}
+		return strings.TrimRight(string(raw), "\r\n"), nil
+	case strings.HasPrefix(value, SECRET_SECRET_PREFIX):
+		if err := this.load(); err != nil {
+			return "", fmt.Errorf("%s: %s", name, err)
+		}
+		key := strings.TrimPrefix(value, SECRET_SECRET_PREFIX)
+		v, ok := this.secrets[key]
+		if !ok {
+			return "", fmt.Errorf("%s: secret %s not found in %s", name, key, this.secretsFile)
+		}
+		return v, nil
+	}
+	if value != "" {
+		log.Warnf("config: %s is a plaintext password, use %s, %s or %s instead",
+			name, SECRET_ENV_PREFIX, SECRET_FILE_PREFIX, SECRET_SECRET_PREFIX)
+	}
+	return value, nil
+}
+
+func (this *secretResolver) load() error {
+	if this.secrets != nil {
+		return nil
+	}
+	if this.secretsFile == "" {
+		return fmt.Errorf("SecretsFile is not configured")
+	}
+	raw, err := ReadFile(this.secretsFile)
+	if err != nil {
+		return err
+	}
+	masterKey, err := MasterKey()
+	if err != nil {
+		return err
+	}
+	this.secrets, err = DecryptSecrets(raw, masterKey)
+	return err
+}
+
+// resolveSecrets replaces every password reference in the config by its value
+func (this *ServiceConfig) resolveSecrets() error {
+	resolver := &secretResolver{secretsFile: this.SecretsFile}
+	var err error
+
+	if this.PolyConfig != nil {
+		if this.PolyConfig.WalletPwd == "" {
+			this.PolyConfig.WalletPwd = os.Getenv(ENV_POLY_WALLET_PWD)
+		} else if this.PolyConfig.WalletPwd, err = resolver.resolve("PolyConfig.WalletPwd", this.PolyConfig.WalletPwd); err != nil {
+			return err
+		}
+	}
+	if this.ETHConfig != nil {
+		for k, v := range this.ETHConfig.KeyStorePwdSet {
+			if this.ETHConfig.KeyStorePwdSet[k], err = resolver.resolve("ETHConfig.KeyStorePwdSet."+k, v); err != nil {
+				return err
+			}
+		}
+	}
+	if this.AdminToken, err = resolver.resolve("AdminToken", this.AdminToken); err != nil {
+		return err
+	}
+	if this.TreasuryConfig != nil {
+		if this.TreasuryConfig.KeyStorePwd, err = resolver.resolve("TreasuryConfig.KeyStorePwd", this.TreasuryConfig.KeyStorePwd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyStorePwdFromEnv looks up the keystore password of addr in the environment,
+// first RELAYER_KEYSTORE_PWD_<ADDR> and then RELAYER_KEYSTORE_PWD.
+func KeyStorePwdFromEnv(addr string) (string, bool) {
+	if v, ok := os.LookupEnv(ENV_KEYSTORE_PWD + "_" + strings.ToUpper(strings.TrimPrefix(strings.ToLower(addr), "0x"))); ok {
+		return v, true
+	}
+	return os.LookupEnv(ENV_KEYSTORE_PWD)
+}
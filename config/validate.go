@@ -0,0 +1,389 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	DEFAULT_BOLTDB_PATH = "boltdb"
+	DEFAULT_ROUTINE_NUM = 64
+
+	DEFAULT_ETH_MONITOR_INTERVAL  = 3
+	DEFAULT_ETH_HEADERS_PER_BATCH = 500
+	DEFAULT_ETH_BLOCK_CONFIG      = ETH_PROOF_USERFUL_BLOCK
+
+	DEFAULT_COSMOS_HEADERS_PER_BATCH = 100
+	DEFAULT_COSMOS_LISTEN_INTERVAL   = 1
+	DEFAULT_COSMOS_CONFIRM_TIMEOUT   = 300
+	DEFAULT_COSMOS_FAST_FORWARD_GAP  = 1000
+	DEFAULT_SPAN_INTERVAL            = 60
+
+	DEFAULT_TOPUP_CHECK_INTERVAL = 60
+
+	DEFAULT_FEE_DEFER_INTERVAL = 600
+	DEFAULT_FEE_CHECK_TTL      = 30
+
+	DEFAULT_VALUE_CAP_WINDOW = 86400
+
+	ADMIN_TOKEN_MIN_LEN = 16
+
+	TARGET_INBOUND  = "inbound"
+	TARGET_OUTBOUND = "outbound"
+)
+
+// ValidationError collects every problem found in a config, so that they can
+// all be fixed at once.
+type ValidationError struct {
+	Errs []string
+}
+
+func (this *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config:\n  %s", strings.Join(this.Errs, "\n  "))
+}
+
+func (this *ValidationError) add(format string, a ...interface{}) {
+	this.Errs = append(this.Errs, fmt.Sprintf(format, a...))
+}
+
+// SetDefaults fills every zero value the relayer cannot run with
+func (this *ServiceConfig) SetDefaults() {
+	if this.BoltDbPath == "" {
+		this.BoltDbPath = DEFAULT_BOLTDB_PATH
+	}
+	if this.RoutineNum == 0 {
+		this.RoutineNum = DEFAULT_ROUTINE_NUM
+	}
+	if this.FeeCheckTTL == 0 {
+		this.FeeCheckTTL = DEFAULT_FEE_CHECK_TTL
+	}
+	if this.ETHConfig != nil {
+		if this.ETHConfig.MonitorInterval == 0 {
+			this.ETHConfig.MonitorInterval = DEFAULT_ETH_MONITOR_INTERVAL
+		}
+		if this.ETHConfig.HeadersPerBatch == 0 {
+			this.ETHConfig.HeadersPerBatch = DEFAULT_ETH_HEADERS_PER_BATCH
+		}
+		if this.ETHConfig.BlockConfig == 0 {
+			this.ETHConfig.BlockConfig = DEFAULT_ETH_BLOCK_CONFIG
+		}
+		if this.ETHConfig.ProducerCheck == "" {
+			this.ETHConfig.ProducerCheck = PRODUCER_CHECK_SIGNER
+		}
+	}
+	if this.TendermintConfig == nil {
+		this.TendermintConfig = &TendermintConfig{}
+	}
+	if this.TendermintConfig.HeadersPerBatch == 0 {
+		this.TendermintConfig.HeadersPerBatch = DEFAULT_COSMOS_HEADERS_PER_BATCH
+	}
+	if this.TendermintConfig.CosmosListenInterval == 0 {
+		this.TendermintConfig.CosmosListenInterval = DEFAULT_COSMOS_LISTEN_INTERVAL
+	}
+	if this.TendermintConfig.ConfirmTimeout == 0 {
+		this.TendermintConfig.ConfirmTimeout = DEFAULT_COSMOS_CONFIRM_TIMEOUT
+	}
+	if this.TendermintConfig.FastForwardGap == 0 {
+		this.TendermintConfig.FastForwardGap = DEFAULT_COSMOS_FAST_FORWARD_GAP
+	}
+	if this.TendermintConfig.SpanInterval == 0 {
+		this.TendermintConfig.SpanInterval = DEFAULT_SPAN_INTERVAL
+	}
+	if this.TreasuryConfig != nil && this.TreasuryConfig.CheckInterval == 0 {
+		this.TreasuryConfig.CheckInterval = DEFAULT_TOPUP_CHECK_INTERVAL
+	}
+	if p := this.FeePolicy; p != nil {
+		if p.UnpaidAction == "" {
+			p.UnpaidAction = FEE_ACTION_REJECT
+		}
+		if p.LowFeeAction == "" {
+			p.LowFeeAction = FEE_ACTION_DEFER
+		}
+		if p.DeferInterval == 0 {
+			p.DeferInterval = DEFAULT_FEE_DEFER_INTERVAL
+		}
+	}
+	if this.ValueCaps != nil {
+		for _, v := range this.ValueCaps.Assets {
+			if v != nil && v.Window == 0 {
+				v.Window = DEFAULT_VALUE_CAP_WINDOW
+			}
+		}
+	}
+}
+
+// Validate checks the config after SetDefaults. It returns a *ValidationError
+// listing every problem found.
+func (this *ServiceConfig) Validate() error {
+	verr := &ValidationError{}
+
+	if this.RoutineNum < 0 {
+		verr.add("RoutineNum must be positive, got %d", this.RoutineNum)
+	}
+	if this.AdminAddr != "" {
+		if _, _, err := net.SplitHostPort(this.AdminAddr); err != nil {
+			verr.add("AdminAddr: %s", err)
+		}
+	}
+	if this.AdminToken != "" && len(this.AdminToken) < ADMIN_TOKEN_MIN_LEN {
+		verr.add("AdminToken must have at least %d characters", ADMIN_TOKEN_MIN_LEN)
+	}
+	if this.AlertWebhook != "" {
+		checkURL(verr, "AlertWebhook", this.AlertWebhook)
+	}
+
+	if this.PolyConfig == nil {
+		verr.add("PolyConfig is required")
+	} else {
+		checkURL(verr, "PolyConfig.RestURL", this.PolyConfig.RestURL)
+		if this.PolyConfig.SignerURL != "" {
+			checkURL(verr, "PolyConfig.SignerURL", this.PolyConfig.SignerURL)
+			if this.PolyConfig.SignerPublicKey == "" {
+				verr.add("PolyConfig.SignerPublicKey is required with PolyConfig.SignerURL")
+			}
+		} else if this.PolyConfig.WalletFile == "" {
+			verr.add("PolyConfig.WalletFile is required unless PolyConfig.SignerURL is set")
+		}
+	}
+
+	if this.ETHConfig == nil {
+		verr.add("ETHConfig is required")
+	} else {
+		checkURL(verr, "ETHConfig.RestURL", this.ETHConfig.RestURL)
+		if this.ETHConfig.SideChainId == 0 {
+			verr.add("ETHConfig.SideChainId is required")
+		}
+		checkAddress(verr, "ETHConfig.ECCMContractAddress", this.ETHConfig.ECCMContractAddress)
+		checkAddress(verr, "ETHConfig.ECCDContractAddress", this.ETHConfig.ECCDContractAddress)
+		if this.ETHConfig.SignerURL != "" {
+			checkURL(verr, "ETHConfig.SignerURL", this.ETHConfig.SignerURL)
+		} else if this.ETHConfig.KeyStorePath == "" {
+			verr.add("ETHConfig.KeyStorePath is required unless ETHConfig.SignerURL is set")
+		}
+		for k := range this.ETHConfig.KeyStorePwdSet {
+			if !common.IsHexAddress(k) {
+				verr.add("ETHConfig.KeyStorePwdSet: %s is not a hex address", k)
+			}
+		}
+		if this.ETHConfig.HeadersPerBatch < 0 {
+			verr.add("ETHConfig.HeadersPerBatch must be positive, got %d", this.ETHConfig.HeadersPerBatch)
+		}
+		switch this.ETHConfig.ProducerCheck {
+		case PRODUCER_CHECK_SIGNER, PRODUCER_CHECK_OFF:
+		default:
+			verr.add("ETHConfig.ProducerCheck: unknown value %s, expect %s or %s", this.ETHConfig.ProducerCheck,
+				PRODUCER_CHECK_SIGNER, PRODUCER_CHECK_OFF)
+		}
+	}
+
+	tc := this.TendermintConfig
+	checkURL(verr, "TendermintConfig.CosmosRpcAddr", tc.CosmosRpcAddr)
+	if tc.SideChainId == 0 {
+		verr.add("TendermintConfig.SideChainId is required")
+	}
+	if tc.CosmosStartHeight < 0 {
+		verr.add("TendermintConfig.CosmosStartHeight must not be negative, got %d", tc.CosmosStartHeight)
+	}
+	if tc.HeadersPerBatch < 0 {
+		verr.add("TendermintConfig.HeadersPerBatch must be positive, got %d", tc.HeadersPerBatch)
+	}
+	if tc.CosmosListenInterval < 0 {
+		verr.add("TendermintConfig.CosmosListenInterval must be positive, got %d", tc.CosmosListenInterval)
+	}
+	if tc.FastForwardGap < -1 {
+		verr.add("TendermintConfig.FastForwardGap must be positive or -1, got %d", tc.FastForwardGap)
+	}
+	if tc.ConfirmTimeout < 0 {
+		verr.add("TendermintConfig.ConfirmTimeout must be positive, got %d", tc.ConfirmTimeout)
+	}
+
+	for i, targets := range this.TargetContracts {
+		for addr, dirs := range targets {
+			name := fmt.Sprintf("TargetContracts[%d].%s", i, addr)
+			if !common.IsHexAddress(addr) {
+				verr.add("%s: not a hex address", name)
+			}
+			for dir := range dirs {
+				if dir != TARGET_INBOUND && dir != TARGET_OUTBOUND {
+					verr.add("%s: unknown direction %s, expect %s or %s", name, dir, TARGET_INBOUND, TARGET_OUTBOUND)
+				}
+			}
+		}
+	}
+
+	if this.ContractsFile != "" {
+		if len(this.TargetContracts) > 0 {
+			verr.add("TargetContracts and ContractsFile are exclusive, move TargetContracts to %s", this.ContractsFile)
+		}
+		if _, err := LoadContractRules(this.ContractsFile); err != nil {
+			verr.add("ContractsFile: %s", err)
+		}
+	}
+
+	for i, urls := range this.BridgeUrl {
+		for j, u := range urls {
+			checkURL(verr, fmt.Sprintf("BridgeUrl[%d][%d]", i, j), u)
+		}
+	}
+
+	if t := this.TreasuryConfig; t != nil {
+		if t.KeyStorePath == "" {
+			verr.add("TreasuryConfig.KeyStorePath is required")
+		}
+		if t.Address != "" && !common.IsHexAddress(t.Address) {
+			verr.add("TreasuryConfig.Address: %s is not a hex address", t.Address)
+		}
+		floor := checkAmount(verr, "TreasuryConfig.FloorBalance", t.FloorBalance)
+		target := checkAmount(verr, "TreasuryConfig.TargetBalance", t.TargetBalance)
+		checkAmount(verr, "TreasuryConfig.DailyCap", t.DailyCap)
+		if floor != nil && target != nil && target.Cmp(floor) <= 0 {
+			verr.add("TreasuryConfig.TargetBalance %s must be greater than FloorBalance %s", t.TargetBalance, t.FloorBalance)
+		}
+		if t.MaxTopUpsPerDay < 0 {
+			verr.add("TreasuryConfig.MaxTopUpsPerDay must not be negative, got %d", t.MaxTopUpsPerDay)
+		}
+	}
+
+	if p := this.FeePolicy; p != nil {
+		for k, v := range p.MinFee {
+			if k != "*" {
+				chain := strings.SplitN(k, ":", 2)
+				if _, err := strconv.ParseUint(chain[0], 10, 64); err != nil {
+					verr.add("FeePolicy.MinFee: key %s is not fromChainId, fromChainId:toContract or *", k)
+				} else if len(chain) == 2 && !common.IsHexAddress(chain[1]) {
+					verr.add("FeePolicy.MinFee: %s in key %s is not a hex address", chain[1], k)
+				}
+			}
+			checkFee(verr, fmt.Sprintf("FeePolicy.MinFee[%s]", k), v)
+		}
+		for i, v := range p.FreeContracts {
+			checkAddress(verr, fmt.Sprintf("FeePolicy.FreeContracts[%d]", i), v)
+		}
+		for i, v := range p.FreeSources {
+			chain := strings.SplitN(v, ":", 2)
+			if _, err := strconv.ParseUint(chain[0], 10, 64); err != nil || len(chain) != 2 {
+				verr.add("FeePolicy.FreeSources[%d]: %s is not fromChainId:hexAddress", i, v)
+			} else if _, err := hex.DecodeString(strings.TrimPrefix(chain[1], "0x")); err != nil || chain[1] == "" {
+				verr.add("FeePolicy.FreeSources[%d]: %s is not a hex address", i, chain[1])
+			}
+		}
+		if p.GasCostRatio < 0 {
+			verr.add("FeePolicy.GasCostRatio must not be negative, got %v", p.GasCostRatio)
+		} else if p.GasCostRatio > 0 {
+			checkFee(verr, "FeePolicy.MaticPrice", p.MaticPrice)
+		}
+		for name, action := range map[string]string{"UnpaidAction": p.UnpaidAction, "LowFeeAction": p.LowFeeAction} {
+			if action != FEE_ACTION_DEFER && action != FEE_ACTION_REJECT {
+				verr.add("FeePolicy.%s: unknown action %s, expect %s or %s", name, action, FEE_ACTION_DEFER, FEE_ACTION_REJECT)
+			}
+		}
+	}
+
+	if c := this.ValueCaps; c != nil {
+		if len(c.LockProxies) == 0 {
+			verr.add("ValueCaps.LockProxies is required")
+		}
+		for i, v := range c.LockProxies {
+			checkAddress(verr, fmt.Sprintf("ValueCaps.LockProxies[%d]", i), v)
+		}
+		for k, v := range c.Assets {
+			name := fmt.Sprintf("ValueCaps.Assets[%s]", k)
+			if k != "*" {
+				asset := strings.SplitN(k, ":", 2)
+				if _, err := strconv.ParseUint(asset[0], 10, 64); err != nil || len(asset) != 2 {
+					verr.add("ValueCaps.Assets: key %s is not chainId:asset or *", k)
+				} else if _, err := hex.DecodeString(strings.TrimPrefix(asset[1], "0x")); err != nil || asset[1] == "" {
+					verr.add("ValueCaps.Assets: %s in key %s is not a hex asset", asset[1], k)
+				}
+			}
+			if v == nil {
+				verr.add("%s is empty", name)
+				continue
+			}
+			if v.MaxPerTx != "" {
+				checkAmount(verr, name+".MaxPerTx", v.MaxPerTx)
+			}
+			if v.MaxPerWindow != "" {
+				checkAmount(verr, name+".MaxPerWindow", v.MaxPerWindow)
+			}
+		}
+	}
+
+	if len(verr.Errs) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func checkURL(verr *ValidationError, name string, raw string) {
+	if raw == "" {
+		verr.add("%s is required", name)
+		return
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		verr.add("%s: %s", name, err)
+		return
+	}
+	if u.Scheme == "" || u.Host == "" {
+		verr.add("%s: %s is not an absolute url", name, raw)
+	}
+}
+
+func checkAddress(verr *ValidationError, name string, addr string) {
+	if addr == "" {
+		verr.add("%s is required", name)
+	} else if !common.IsHexAddress(addr) {
+		verr.add("%s: %s is not a hex address", name, addr)
+	}
+}
+
+func checkAmount(verr *ValidationError, name string, amount string) *big.Int {
+	if amount == "" {
+		verr.add("%s is required", name)
+		return nil
+	}
+	v, ok := new(big.Int).SetString(amount, 10)
+	if !ok || v.Sign() < 0 {
+		verr.add("%s: %s is not an amount in wei", name, amount)
+		return nil
+	}
+	return v
+}
+
+// checkFee checks a fee as the bridge reports it, a decimal number
+func checkFee(verr *ValidationError, name string, fee string) *big.Float {
+	if fee == "" {
+		verr.add("%s is required", name)
+		return nil
+	}
+	v, ok := new(big.Float).SetString(fee)
+	if !ok || v.Sign() < 0 {
+		verr.add("%s: %s is not a fee", name, fee)
+		return nil
+	}
+	return v
+}
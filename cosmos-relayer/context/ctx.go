@@ -22,19 +22,16 @@ import (
 
 	"github.com/polynetwork/poly/core/types"
 	poly_go_sdkp "github.com/polynetwork/polygon-relayer/poly_go_sdk"
-	poly_go_sdk "github.com/polynetwork/poly-go-sdk"
 
 	tcrypto "github.com/christianxiao/tendermint/crypto"
-	rpcclient "github.com/christianxiao/tendermint/rpc/client"
 	rpctypes "github.com/christianxiao/tendermint/rpc/core/types"
 
 	"github.com/polynetwork/polygon-relayer/config"
 	"github.com/polynetwork/polygon-relayer/cosmos-sdk/codec"
 	ctypes "github.com/polynetwork/polygon-relayer/cosmos-sdk/types"
 	"github.com/polynetwork/polygon-relayer/db"
+	"github.com/polynetwork/polygon-relayer/heimdall"
 	cosmos "github.com/polynetwork/polygon-relayer/types"
-
-	cryptoamino "github.com/christianxiao/tendermint/crypto/encoding/amino"
 )
 
 type InfoType int
@@ -49,7 +46,7 @@ var (
 	RCtx = &Ctx{}
 )
 
-func InitCtx(conf *config.TendermintConfig, db *db.BoltDB, poly *poly_go_sdkp.PolySdk, tclinet *rpcclient.HTTP) error {
+func InitCtx(conf *config.TendermintConfig, db *db.BoltDB, poly *poly_go_sdkp.PolySdk, hclient *heimdall.Client) error {
 	var (
 		err error
 	)
@@ -60,15 +57,20 @@ func InitCtx(conf *config.TendermintConfig, db *db.BoltDB, poly *poly_go_sdkp.Po
 	RCtx.ToPoly = make(chan *CosmosInfo, ChanBufSize)
 
 	// prepare COSMOS staff
-	RCtx.CMRpcCli = tclinet
-
-	cdc := codec.New()
-	cryptoamino.RegisterAmino(cdc)
-	RCtx.CMCdc = cdc
+	RCtx.Heimdall = hclient
+	RCtx.CMCdc = hclient.Codec()
 
 	RCtx.Poly = poly
-	if RCtx.PolyAcc, err = GetAccountByPassword(RCtx.Poly, conf.PolyWallet, []byte(conf.PolyWalletPwd)); err != nil {
-		return err
+	if conf.PolySignerURL != "" {
+		if RCtx.PolyAcc, err = poly_go_sdkp.NewRemoteSigner(conf.PolySignerURL, conf.PolySignerPublicKey); err != nil {
+			return err
+		}
+	} else {
+		acc, err := GetAccountByPassword(RCtx.Poly, conf.PolyWallet, []byte(conf.PolyWalletPwd))
+		if err != nil {
+			return err
+		}
+		RCtx.PolyAcc = poly_go_sdkp.NewAccountSigner(acc)
 	}
 
 	RCtx.Db = db
@@ -85,7 +87,7 @@ type Ctx struct {
 	ToPoly   chan *CosmosInfo
 
 	// Cosmos
-	CMRpcCli *rpcclient.HTTP
+	Heimdall *heimdall.Client
 	CMPrivk  tcrypto.PrivKey
 	CMAcc    ctypes.AccAddress
 	CMSeq    *CosmosSeq
@@ -96,7 +98,7 @@ type Ctx struct {
 
 	// Poly chain
 	Poly    *poly_go_sdkp.PolySdk
-	PolyAcc *poly_go_sdk.Account
+	PolyAcc poly_go_sdkp.Signer
 
 	// DB
 	Db *db.BoltDB
@@ -19,7 +19,6 @@ package service
 
 import (
 	"bytes"
-	"fmt"
 	"strings"
 	"time"
 
@@ -29,8 +28,6 @@ import (
 	polycosmos "github.com/polynetwork/polygon-relayer/poly/native/header_sync/cosmos"
 	cosmos "github.com/polynetwork/polygon-relayer/types"
 
-	tdmt_types "github.com/christianxiao/tendermint/types"
-
 	"github.com/polynetwork/polygon-relayer/cosmos-relayer/context"
 	"github.com/polynetwork/polygon-relayer/log"
 )
@@ -65,21 +62,33 @@ func CosmosListen() {
 	for {
 		select {
 		case <-tick.C:
-			status, err := ctx.CMRpcCli.Status()
-		
+			latest, err := ctx.Heimdall.LatestHeight()
+
 			switch {
 			case err != nil:
 				log.LogTender.Errorf("[ListenCosmos] failed to get height of COSMOS, retry after %d sec: %v",
 					ctx.Conf.CosmosListenInterval, err)
 				continue
-			case status.SyncInfo.LatestBlockHeight-1 <= lastRight:
+			case latest-1 <= lastRight:
 				continue
 			}
 
-			log.LogTender.Infof("[ListenCosmos] status: left: %d, status: %d, diff: %d", left, status.SyncInfo.LatestBlockHeight, status.SyncInfo.LatestBlockHeight-left)
-			right := status.SyncInfo.LatestBlockHeight - 1
+			log.LogTender.Infof("[ListenCosmos] status: left: %d, status: %d, diff: %d", left, latest, latest-left)
+			right := latest - 1
 			log.LogTender.Infof("[ListenCosmos] CosmosListen left: %d, right: %d, diff: %d", left, right, right-left)
 
+			// far behind, e.g. after an outage, only look for epoch switching heights
+			if gap := ctx.Conf.FastForwardGap; gap > 0 && right-left > gap {
+				done, err := fastForwardCosmos(left, right)
+				if err != nil {
+					log.LogTender.Errorf("[ListenCosmos] fast forward from %d to %d stopped at %d, retry after %d sec: %v",
+						left, right, done, ctx.Conf.CosmosListenInterval, err)
+				}
+				lastRight = done
+				left = done
+				continue
+			}
+
 			//var hdr *cosmos.CosmosHeader
 /* 			hdr, err := getCosmosHdr(right)
 			if err != nil {
@@ -122,7 +131,8 @@ func CosmosListen() {
 				log.LogTender.Infof("[ListenCosmos] left %d, right %d, h %d, infoArr.Hdrs: %d batch: %d",
 					left, right, h,
 					len(infoArr.Hdrs), ctx.Conf.HeadersPerBatch)
-				if len(infoArr.Hdrs) >= ctx.Conf.HeadersPerBatch || h == right {
+				// flush at once when bor header sync waits for this height
+				if len(infoArr.Hdrs) >= ctx.Conf.HeadersPerBatch || h == right || h == wantedHeimdallHeight() {
 					log.LogTender.Infof("[ListenCosmos] ctx.ToPoly - left %d, right %d, h %d, infoArr.Hdrs: %d batch: %d data: %w",
 						left, right, h,
 						len(infoArr.Hdrs), ctx.Conf.HeadersPerBatch, infoArr)
@@ -168,11 +178,12 @@ func GetBestCosmosHeightForBor() (int64, error) {
 
 	log.LogTender.Infof("GetBestCosmosHeightForBor, ( cosmos height on Poly: %d )", currHeight)
 
-	if dbh := ctx.Db.GetCosmosHeight(); dbh > currHeight {
-		log.LogTender.Infof("GetBestCosmosHeightForBor, ( cosmos height in DB: %d )", dbh)
-		currHeight = dbh
+	// only heights whose headers are confirmed on Poly, see heimdallSchedule
+	if confirmed := ConfirmedHeimdallHeight(); confirmed > currHeight {
+		log.LogTender.Infof("GetBestCosmosHeightForBor, ( cosmos height confirmed: %d )", confirmed)
+		currHeight = confirmed
 	}
-	
+
 	return currHeight, nil
 }
 
@@ -189,6 +200,7 @@ func beforeCosmosListen() (int64, *time.Ticker, error) {
 		log.LogTender.Infof("beforeCosmosListen, ( cosmos height in DB: %d )", dbh)
 		currHeight = dbh
 	}
+	confirmHeimdallHeight(currHeight)
 	if ctx.Conf.CosmosStartHeight != 0 {
 		currHeight = ctx.Conf.CosmosStartHeight
 	}
@@ -219,7 +231,7 @@ func GetCosmosHeightFromPoly() (int64, error) {
 // `headersToRelay` record all hdrs need to relay. When need to update new height to
 // get proof, relayer update `rightPtr` and return.
 func checkCosmosHeight(h int64, infoArr *context.CosmosInfo) (*context.CosmosInfo, error) {
-	rc, err := ctx.CMRpcCli.Commit(&h)
+	rc, err := ctx.Heimdall.Commit(h)
 	if err != nil {
 		return infoArr, err
 	}
@@ -227,7 +239,7 @@ func checkCosmosHeight(h int64, infoArr *context.CosmosInfo) (*context.CosmosInf
 		h, !bytes.Equal(rc.Header.ValidatorsHash, rc.Header.NextValidatorsHash))
 
 	if !bytes.Equal(rc.Header.ValidatorsHash, rc.Header.NextValidatorsHash) {
-		vSet, err := getValidators(h)
+		vSet, err := ctx.Heimdall.ValidatorSet(h)
 		if err != nil {
 			return infoArr, err
 		}
@@ -236,14 +248,11 @@ func checkCosmosHeight(h int64, infoArr *context.CosmosInfo) (*context.CosmosInf
 			Commit:  rc.Commit,
 			Valsets: vSet,
 		}
-		val, err := ctx.Poly.GetStorage(utils.CrossChainManagerContractAddress.ToHexString(),
-			append(append([]byte(mhcomm.EPOCH_SWITCH), utils.GetUint64Bytes(ctx.Conf.SideChainId)...),
-				utils.GetUint64Bytes(uint64(h))...))
+		committed, err := isCosmosHdrCommitted(h)
 		if err != nil {
 			return infoArr, err
 		}
-		// check if this header is not committed on Poly
-		if len(val) == 0 {
+		if !committed {
 			infoArr.Hdrs = append(infoArr.Hdrs, hdr)
 		}
 	}
@@ -251,40 +260,31 @@ func checkCosmosHeight(h int64, infoArr *context.CosmosInfo) (*context.CosmosInf
 	return infoArr, nil
 }
 
-func getValidators(h int64) ([]*tdmt_types.Validator, error) {
-
-	vSet := make([]*tdmt_types.Validator, 0)
-
-	// TODO: this changed!, don't know how to get full validators, only return first 100
-	res, err := ctx.CMRpcCli.Validators(&h)
+// check if the epoch switching header at h is committed on Poly
+func isCosmosHdrCommitted(h int64) (bool, error) {
+	val, err := ctx.Poly.GetStorage(utils.CrossChainManagerContractAddress.ToHexString(),
+		append(append([]byte(mhcomm.EPOCH_SWITCH), utils.GetUint64Bytes(ctx.Conf.SideChainId)...),
+			utils.GetUint64Bytes(uint64(h))...))
 	if err != nil {
-		if strings.Contains(err.Error(), "page should be within") {
-			return vSet, nil
-		}
-		return nil, err
-	}
-	log.LogTender.Infof("cosmos getValidators - height: %d, validators: %d ", h, len(res.Validators))
-	// In case tendermint don't give relayer the right error
-	if len(res.Validators) == 0 {
-		return vSet, nil
+		return false, err
 	}
-	vSet = append(vSet, res.Validators...)
-
-	return vSet, nil
+	return len(val) != 0, nil
 }
 
-func getCosmosHdr(h int64) (*cosmos.CosmosHeader, error) {
-	rc, err := ctx.CMRpcCli.Commit(&h)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get Commit of height %d: %v", h, err)
-	}
-	vSet, err := getValidators(h)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get Validators of height %d: %v", h, err)
+// uncommittedCosmosHdrs drops the headers committed on Poly since they were
+// fetched, e.g. by a retried batch. A header is kept if Poly can not be asked.
+func uncommittedCosmosHdrs(hdrs []*cosmos.CosmosHeader) []*cosmos.CosmosHeader {
+	res := make([]*cosmos.CosmosHeader, 0, len(hdrs))
+	for _, hdr := range hdrs {
+		committed, err := isCosmosHdrCommitted(hdr.Header.Height)
+		if err != nil {
+			log.LogTender.Errorf("uncommittedCosmosHdrs - check header %d error: %s", hdr.Header.Height, err)
+		}
+		if committed {
+			log.LogTender.Infof("uncommittedCosmosHdrs - header %d is committed on Poly already", hdr.Header.Height)
+			continue
+		}
+		res = append(res, hdr)
 	}
-	return &cosmos.CosmosHeader{
-		Header:  *rc.Header,
-		Commit:  rc.Commit,
-		Valsets: vSet,
-	}, nil
+	return res
 }
@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"bytes"
+
+	tdmt_types "github.com/christianxiao/tendermint/types"
+
+	"github.com/polynetwork/polygon-relayer/cosmos-relayer/context"
+	"github.com/polynetwork/polygon-relayer/log"
+	cosmos "github.com/polynetwork/polygon-relayer/types"
+)
+
+// epochScanner finds the epoch switching heights of a heimdall height range by
+// bisection on the validators hash: a range whose first and last headers have
+// the same validators, and whose first header keeps them, is taken to hold no
+// switch. It fetches about 2 * switches * log2(range) headers instead of every
+// one. A validator set switched away and back inside a range is not found,
+// which is harmless, Poly keeps the set the later headers are signed by.
+type epochScanner struct {
+	hdrs  map[int64]*tdmt_types.Header
+	calls int
+}
+
+func newEpochScanner() *epochScanner {
+	return &epochScanner{
+		hdrs: make(map[int64]*tdmt_types.Header),
+	}
+}
+
+func (this *epochScanner) header(h int64) (*tdmt_types.Header, error) {
+	if hdr, ok := this.hdrs[h]; ok {
+		return hdr, nil
+	}
+	rc, err := ctx.Heimdall.Commit(h)
+	if err != nil {
+		return nil, err
+	}
+	this.calls++
+	this.hdrs[h] = rc.Header
+	return rc.Header, nil
+}
+
+// scan appends to res the heights in [lo, hi) whose next validators differ
+// from their validators, in order.
+func (this *epochScanner) scan(lo, hi int64, res []int64) ([]int64, error) {
+	first, err := this.header(lo)
+	if err != nil {
+		return res, err
+	}
+	if hi-lo == 1 {
+		if !bytes.Equal(first.ValidatorsHash, first.NextValidatorsHash) {
+			res = append(res, lo)
+		}
+		return res, nil
+	}
+	last, err := this.header(hi)
+	if err != nil {
+		return res, err
+	}
+	if bytes.Equal(first.ValidatorsHash, last.ValidatorsHash) && bytes.Equal(first.ValidatorsHash, first.NextValidatorsHash) {
+		return res, nil
+	}
+	mid := lo + (hi-lo)/2
+	if res, err = this.scan(lo, mid, res); err != nil {
+		return res, err
+	}
+	return this.scan(mid, hi, res)
+}
+
+// fastForwardCosmos relays the epoch switching headers in (left, right] found
+// by an epochScanner, in batches as CosmosListen does. It returns the height
+// heimdall headers are sent up to, which is right unless an error happened.
+func fastForwardCosmos(left, right int64) (int64, error) {
+	scanner := newEpochScanner()
+	switches, err := scanner.scan(left+1, right+1, make([]int64, 0))
+	if err != nil {
+		return left, err
+	}
+	log.LogTender.Infof("[ListenCosmos] fast forward from %d to %d, epoch switching heights: %v, headers fetched: %d",
+		left, right, switches, scanner.calls)
+
+	infoArr := &context.CosmosInfo{
+		Type: context.TyHeader,
+		Hdrs: make([]*cosmos.CosmosHeader, 0),
+	}
+	flush := func(h int64) {
+		ctx.ToPoly <- infoArr
+		ctx.ToPoly <- &context.CosmosInfo{
+			Type:   context.TyUpdateHeight,
+			Height: h,
+		}
+		infoArr = &context.CosmosInfo{
+			Type: context.TyHeader,
+			Hdrs: make([]*cosmos.CosmosHeader, 0),
+		}
+		left = h
+	}
+	for _, h := range switches {
+		if infoArr, err = checkCosmosHeight(h, infoArr); err != nil {
+			// headers before h are relayed, continue from there
+			if h-1 > left {
+				flush(h - 1)
+			}
+			return left, err
+		}
+		// flush at once when bor header sync waits for this height
+		if wanted := wantedHeimdallHeight(); len(infoArr.Hdrs) >= ctx.Conf.HeadersPerBatch || wanted != 0 && wanted <= h {
+			flush(h)
+		}
+	}
+	flush(right)
+	return right, nil
+}
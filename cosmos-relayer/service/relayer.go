@@ -18,18 +18,43 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/polynetwork/polygon-relayer/cosmos-relayer/context"
 	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
 
 	mcli "github.com/polynetwork/poly-go-sdk/client"
+	"github.com/polynetwork/poly/common"
 
 	mcosmos "github.com/polynetwork/polygon-relayer/types"
 )
 
+const (
+	HEIMDALL_MAX_SUBMITS          = 5                // submits of one batch before giving up
+	HEIMDALL_RESUBMIT_BACKOFF     = 10 * time.Second // wait before the first resubmit, doubled after each
+	HEIMDALL_RESUBMIT_BACKOFF_MAX = 5 * time.Minute
+
+	METRIC_HEIMDALL_SUBMITTED        = "heimdall.batches_submitted"
+	METRIC_HEIMDALL_CONFIRMED        = "heimdall.batches_confirmed"
+	METRIC_HEIMDALL_TIMEOUTS         = "heimdall.confirm_timeouts"
+	METRIC_HEIMDALL_RESUBMITS        = "heimdall.resubmits"
+	METRIC_HEIMDALL_CONFIRM_MS       = "heimdall.confirm_ms"
+	METRIC_HEIMDALL_CONFIRMED_HEIGHT = "heimdall.confirmed_height"
+	METRIC_HEIMDALL_BISECTS          = "heimdall.bisects"
+	METRIC_HEIMDALL_REJECTED         = "heimdall.rejected_headers"
+
+	ALERT_HEIMDALL_COMMIT_STUCK    = "heimdall_commit_stuck"
+	ALERT_HEIMDALL_HEADER_REJECTED = "heimdall_header_rejected"
+)
+
+// poly rpc error codes of a tx poly refuses: invalid transaction, smart
+// contract exec and pre exec errors
+var POLY_REJECTION_CODES = []int64{43001, 47001, 47002}
+
 func StartRelay() {
 	go ToPolyRoutine()
 	// go ToCosmosRoutine()
@@ -39,31 +64,25 @@ func StartRelay() {
 // When type is `TyHeader`, we must finish the procession for this message
 // before processing the transaction-messages.
 // When type is `TyTx`, we relay this transaction info and its proof to Poly.
-// When type is `TyUpdateHeight`, we update the cosmos height that already
-// checked in our db.
+// When type is `TyUpdateHeight`, we relay the headers received before it and
+// update the cosmos height in our db once they are confirmed, see headerRelay.
 // This run as a go-routine
 func ToPolyRoutine() {
 	log.LogTender.Infof("relayer.ToPolyRoutine - start")
 
+	relay := newHeaderRelay()
+	var hdrs []*mcosmos.CosmosHeader
 	for val := range ctx.ToPoly {
 		switch val.Type {
 		case context.TyHeader:
-			log.LogTender.Infof("relayer.ToPolyRoutine - handleCosmosHdrs, lenth: %d", len(val.Hdrs))
-			if err := handleCosmosHdrs(val.Hdrs); err != nil {
-				log.LogTender.Errorf("relayer.ToPolyRoutine - handleCosmosHdrs, lenth: %d, err: %w", len(val.Hdrs), err)
-				// panic(err)
-				continue
-			}
+			hdrs = append(hdrs, val.Hdrs...)
 		case context.TyTx:
 			// go handleCosmosTx(val.Tx, val.Hdrs[0])
 			continue
 		case context.TyUpdateHeight:
-			log.LogTender.Infof("relayer.ToPolyRoutine - TyUpdateHeight, height: %d", val.Height)
-			go func() {
-				if err := ctx.Db.SetCosmosHeight(val.Height); err != nil {
-					log.LogTender.Errorf("failed to update cosmos height: %v", err)
-				}
-			}()
+			log.LogTender.Infof("relayer.ToPolyRoutine - TyUpdateHeight, height: %d, headers: %d", val.Height, len(hdrs))
+			relay.handle(hdrs, val.Height)
+			hdrs = nil
 		}
 	}
 }
@@ -72,6 +91,7 @@ func ToPolyRoutine() {
 // Ploygon tx committing headers confirmed. This guarantee that the next
 // cross-chain txs next to relay can be proved on Poly.
 func handleCosmosHdrs(headers []*mcosmos.CosmosHeader) error {
+	headers = uncommittedCosmosHdrs(headers)
 	for i := 0; i < len(headers); i += ctx.Conf.HeadersPerBatch {
 		var hdrs []*mcosmos.CosmosHeader
 		if i+ctx.Conf.HeadersPerBatch > len(headers) {
@@ -79,59 +99,174 @@ func handleCosmosHdrs(headers []*mcosmos.CosmosHeader) error {
 		} else {
 			hdrs = headers[i : i+ctx.Conf.HeadersPerBatch]
 		}
-		info := make([]string, len(hdrs))
-		raw := make([][]byte, len(hdrs))
-		for i, h := range hdrs {
-			r, err := ctx.CMCdc.MarshalBinaryBare(*h)
-			if err != nil {
-				log.LogTender.Fatalf("[handleCosmosHdr] failed to marshal CosmosHeader: %v", err)
-				return err
-			}
-			raw[i] = r
-			info[i] = fmt.Sprintf("(hash: %s, height: %d)", h.Header.Hash().String(), h.Header.Height)
-			log.LogTender.Info("[handleCosmosHdr] 2" + info[i])
+		if err := bisectCosmosHdrs(hdrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bisectCosmosHdrs commits a batch. When Poly rejects it, the headers Poly has
+// already are dropped and the rest is resubmitted, or else the batch is split
+// in halves committed in order, down to the offending headers. It returns an
+// error if any header is left uncommitted.
+func bisectCosmosHdrs(hdrs []*mcosmos.CosmosHeader) error {
+	err := commitCosmosHdrs(hdrs)
+	if err == nil || !errors.Is(err, mcosmos.ErrHeadersRejected) {
+		return err
+	}
+
+	if rest := uncommittedCosmosHdrs(hdrs); len(rest) < len(hdrs) {
+		log.LogTender.Infof("[handleCosmosHdr] dropped %d headers committed on Poly already, resubmit %d", len(hdrs)-len(rest), len(rest))
+		if len(rest) == 0 {
+			return nil
+		}
+		return bisectCosmosHdrs(rest)
+	}
+	if len(hdrs) == 1 {
+		metrics.Add(METRIC_HEIMDALL_REJECTED, 1)
+		metrics.Alert(ALERT_HEIMDALL_HEADER_REJECTED, "poly rejects heimdall header %d (hash: %s): %s",
+			hdrs[0].Header.Height, hdrs[0].Header.Hash().String(), err)
+		return err
+	}
+
+	metrics.Add(METRIC_HEIMDALL_BISECTS, 1)
+	mid := len(hdrs) / 2
+	log.LogTender.Infof("[handleCosmosHdr] bisect rejected heimdall headers %d-%d at %d",
+		hdrs[0].Header.Height, hdrs[len(hdrs)-1].Header.Height, hdrs[mid].Header.Height)
+	// headers carry validator set switches, the later half cannot commit
+	// before the first
+	if err := bisectCosmosHdrs(hdrs[:mid]); err != nil {
+		return err
+	}
+	return bisectCosmosHdrs(hdrs[mid:])
+}
+
+// commitCosmosHdrs commits one batch and waits until it is confirmed on Poly.
+// A batch not confirmed in ConfirmTimeout seconds raises an alert and is
+// submitted again after a growing backoff, any of its txs confirming is enough.
+func commitCosmosHdrs(hdrs []*mcosmos.CosmosHeader) error {
+	info := make([]string, len(hdrs))
+	raw := make([][]byte, len(hdrs))
+	for i, h := range hdrs {
+		r, err := ctx.CMCdc.MarshalBinaryBare(*h)
+		if err != nil {
+			log.LogTender.Errorf("[handleCosmosHdr] failed to marshal CosmosHeader: %v", err)
+			return err
 		}
+		raw[i] = r
+		info[i] = fmt.Sprintf("(hash: %s, height: %d)", h.Header.Hash().String(), h.Header.Height)
+		log.LogTender.Info("[handleCosmosHdr] 2" + info[i])
+	}
 
-	SYNC_RETRY:
-		txhash, err := ctx.Poly.Native.Hs.SyncBlockHeader(ctx.Conf.SideChainId, ctx.PolyAcc.Address,
-			raw, ctx.PolyAcc)
+	first, last := hdrs[0].Header.Height, hdrs[len(hdrs)-1].Header.Height
+	timeout := time.Duration(ctx.Conf.ConfirmTimeout) * time.Second
+	backoff := HEIMDALL_RESUBMIT_BACKOFF
+	txhashes := make([]common.Uint256, 0)
+	for submits := 1; ; submits++ {
+		txhash, err := submitCosmosHdrs(raw, info)
 		if err != nil {
-			if _, ok := err.(mcli.PostErr); ok {
-				log.LogTender.Errorf("[handleCosmosHdr] post error, retry after 10 sec wait: %v", err)
-				context.SleepSecs(10)
-				goto SYNC_RETRY
+			if len(txhashes) == 0 || !strings.Contains(err.Error(), context.NoUsefulHeaders) {
+				return err
 			}
-			if strings.Contains(err.Error(), context.NoUsefulHeaders) {
-				log.LogTender.Errorf("[handleCosmosHdr] your headers could be wrong or already committed: headers: [ %s ], error: %w",
-					strings.Join(info, ", "), err)
-				return fmt.Errorf("[handleCosmosHdr] your headers could be wrong or already committed: headers: [ %s ], error: %w",
-				strings.Join(info, ", "), err)
+			// the headers are committed already, by one of our txs confirmed meanwhile
+			if txhash, h, ok := checkPolyTxs(txhashes); ok {
+				confirmedCosmosHdrs(info, txhash, h, submits)
+				return nil
 			}
-			log.LogTender.Errorf("[handleCosmosHdr] failed to relay cosmos header to Poly: %v", err)
 			return err
 		}
-		tick := time.NewTicker(100 * time.Millisecond)
-		var h uint32
-		startTime := time.Now()
-		for range tick.C {
-			h, _ = ctx.Poly.GetBlockHeightByTxHash(txhash.ToHexString())
-			curr, _ := ctx.Poly.GetCurrentBlockHeight()
-			if h > 0 && curr > h {
-				break
-			}
+		txhashes = append(txhashes, txhash)
+		metrics.Add(METRIC_HEIMDALL_SUBMITTED, 1)
 
-			if startTime.Add(100 * time.Millisecond); startTime.Second() > ctx.Conf.ConfirmTimeout {
-				str := ""
-				for i, hdr := range hdrs {
-					str += fmt.Sprintf("( no.%d: hdr-height: %d, hdr-hash: %s )\n", i+1,
-						hdr.Header.Height, hdr.Header.Hash().String())
-				}
-				panic(fmt.Errorf("tx( %s ) is not confirm for a long time ( over %d sec ): {\n%s}",
-					txhash.ToHexString(), ctx.Conf.ConfirmTimeout, str))
-			}
+		start := time.Now()
+		if txhash, h, ok := waitPolyTxs(txhashes, timeout); ok {
+			metrics.Set(METRIC_HEIMDALL_CONFIRM_MS, int64(time.Since(start)/time.Millisecond))
+			confirmedCosmosHdrs(info, txhash, h, submits)
+			return nil
+		}
+
+		metrics.Add(METRIC_HEIMDALL_TIMEOUTS, 1)
+		metrics.Alert(ALERT_HEIMDALL_COMMIT_STUCK, "poly tx %s committing heimdall headers %d-%d is not confirmed in %d sec, submit %d/%d",
+			txhash.ToHexString(), first, last, ctx.Conf.ConfirmTimeout, submits, HEIMDALL_MAX_SUBMITS)
+		if submits >= HEIMDALL_MAX_SUBMITS {
+			return fmt.Errorf("[handleCosmosHdr] heimdall headers %d-%d submitted %d times: %w", first, last, submits, mcosmos.ErrConfirmTimeout)
 		}
-		log.LogTender.Infof("[handleCosmosHdr] successful to relay header and confirmed on Poly: { headers: [ %s ], poly: "+
-			"(poly_tx: %s, poly_tx_height: %d) }", strings.Join(info, ", "), txhash.ToHexString(), h)
+		log.LogTender.Infof("[handleCosmosHdr] resubmit heimdall headers %d-%d after %s", first, last, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > HEIMDALL_RESUBMIT_BACKOFF_MAX {
+			backoff = HEIMDALL_RESUBMIT_BACKOFF_MAX
+		}
+		metrics.Add(METRIC_HEIMDALL_RESUBMITS, 1)
 	}
-	return nil
+}
+
+func submitCosmosHdrs(raw [][]byte, info []string) (common.Uint256, error) {
+SYNC_RETRY:
+	txhash, err := ctx.Poly.SyncBlockHeader(ctx.Conf.SideChainId, raw, ctx.PolyAcc)
+	if err != nil {
+		if _, ok := err.(mcli.PostErr); ok {
+			log.LogTender.Errorf("[handleCosmosHdr] post error, retry after 10 sec wait: %v", err)
+			context.SleepSecs(10)
+			goto SYNC_RETRY
+		}
+		if strings.Contains(err.Error(), context.NoUsefulHeaders) {
+			log.LogTender.Errorf("[handleCosmosHdr] your headers could be wrong or already committed: headers: [ %s ], error: %s",
+				strings.Join(info, ", "), err)
+			return txhash, fmt.Errorf("[handleCosmosHdr] your headers could be wrong or already committed: headers: [ %s ], error: %s: %w",
+				strings.Join(info, ", "), err, mcosmos.ErrHeadersRejected)
+		}
+		log.LogTender.Errorf("[handleCosmosHdr] failed to relay cosmos header to Poly: %v", err)
+		if isPolyRejection(err) {
+			return txhash, fmt.Errorf("[handleCosmosHdr] failed to relay cosmos header to Poly: %s: %w", err, mcosmos.ErrHeadersRejected)
+		}
+		return txhash, fmt.Errorf("[handleCosmosHdr] failed to relay cosmos header to Poly: %w", err)
+	}
+	return txhash, nil
+}
+
+// isPolyRejection returns whether poly answered that the tx is invalid or fails,
+// rather than the request failing
+func isPolyRejection(err error) bool {
+	for _, code := range POLY_REJECTION_CODES {
+		if strings.Contains(err.Error(), fmt.Sprintf("JsonRpcResponse error code:%d ", code)) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitPolyTxs waits until one of txhashes is confirmed on Poly or timeout elapses
+func waitPolyTxs(txhashes []common.Uint256, timeout time.Duration) (common.Uint256, uint32, bool) {
+	deadline := time.Now().Add(timeout)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for range tick.C {
+		if txhash, h, ok := checkPolyTxs(txhashes); ok {
+			return txhash, h, true
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+	return common.UINT256_EMPTY, 0, false
+}
+
+// checkPolyTxs returns the first of txhashes confirmed on Poly with its height
+func checkPolyTxs(txhashes []common.Uint256) (common.Uint256, uint32, bool) {
+	curr, _ := ctx.Poly.GetCurrentBlockHeight()
+	for _, txhash := range txhashes {
+		h, _ := ctx.Poly.GetBlockHeightByTxHash(txhash.ToHexString())
+		if h > 0 && curr > h {
+			return txhash, h, true
+		}
+	}
+	return common.UINT256_EMPTY, 0, false
+}
+
+func confirmedCosmosHdrs(info []string, txhash common.Uint256, h uint32, submits int) {
+	metrics.Add(METRIC_HEIMDALL_CONFIRMED, 1)
+	metrics.Resolve(ALERT_HEIMDALL_COMMIT_STUCK)
+	log.LogTender.Infof("[handleCosmosHdr] successful to relay header and confirmed on Poly: { headers: [ %s ], poly: "+
+		"(poly_tx: %s, poly_tx_height: %d, submits: %d) }", strings.Join(info, ", "), txhash.ToHexString(), h, submits)
 }
@@ -0,0 +1,45 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	mcli "github.com/polynetwork/poly-go-sdk/client"
+)
+
+func TestIsPolyRejection(t *testing.T) {
+	cases := []struct {
+		err    error
+		reject bool
+	}{
+		{fmt.Errorf("JsonRpcResponse error code:43001 desc:INVALID TRANSACTION result:\"no header you commited is useful\""), true},
+		{fmt.Errorf("sendRawTransaction error: JsonRpcResponse error code:47001 desc:SMARTCODE EXEC ERROR result:\"verify header failed\""), true},
+		{fmt.Errorf("JsonRpcResponse error code:47002 desc:SMARTCODE PREPARE EXEC ERROR result:\"\""), true},
+		{fmt.Errorf("JsonRpcResponse error code:45001 desc:INTERNAL ERROR result:\"\""), false},
+		{fmt.Errorf("read rpc response body error:unexpected EOF"), false},
+		{mcli.PostErr{Err: errors.New("http post request error: i/o timeout")}, false},
+	}
+	for _, c := range cases {
+		if got := isPolyRejection(c.err); got != c.reject {
+			t.Errorf("isPolyRejection(%q) = %v, expect %v", c.err, got, c.reject)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
+	mcosmos "github.com/polynetwork/polygon-relayer/types"
+)
+
+const (
+	HEIMDALL_REPROVE_INTERVAL = 30 * time.Second // wait between retries of failed batches
+
+	METRIC_HEIMDALL_REPROVE_SAVED   = "heimdall.reprove_saved"
+	METRIC_HEIMDALL_REPROVE_PENDING = "heimdall.reprove_pending"
+
+	ALERT_HEIMDALL_REPROVE_CORRUPT = "heimdall_reprove_corrupt"
+)
+
+// cosmosReProve is a heimdall header batch kept in db.CosmosReProve until it
+// is confirmed on Poly
+type cosmosReProve struct {
+	Height int64    // heimdall height checkpointed once the batch is confirmed
+	Hdrs   [][]byte // amino encoded headers
+}
+
+// headerRelay relays the heimdall header batches of the listener and moves the
+// cosmos height in the DB only once a batch is confirmed on Poly. A failed
+// batch is kept in db.CosmosReProve, and while any is kept, the failed batches
+// are retried in height order before new work, which is kept behind them.
+type headerRelay struct {
+	height    int64 // checkpointed heimdall height
+	pending   bool  // whether db.CosmosReProve may hold batches
+	deferred  int64 // height of the empty batches received while pending
+	lastRetry time.Time
+}
+
+func newHeaderRelay() *headerRelay {
+	this := &headerRelay{
+		height:  ctx.Db.GetCosmosHeight(),
+		pending: true, // batches left by the last run
+	}
+	this.dropCorrupt()
+	return this
+}
+
+// dropCorrupt deletes the batches left by the last run that cannot be
+// decoded. The listener fetches every header above the checkpointed height
+// again after a restart, theirs included.
+func (this *headerRelay) dropCorrupt() {
+	batches, err := ctx.Db.GetAllCosmosReProve()
+	if err != nil {
+		log.LogTender.Errorf("headerRelay.dropCorrupt - GetAllCosmosReProve error: %s", err)
+		return
+	}
+	for _, v := range batches {
+		_, _, err := decodeCosmosReProve(v.V)
+		if err == nil {
+			continue
+		}
+		log.LogTender.Errorf("headerRelay.dropCorrupt - saved batch %d cannot be decoded, its headers are fetched again from height %d: %s",
+			v.K, this.height, err)
+		if err = ctx.Db.DeleteCosmosReProve(int64(v.K)); err != nil {
+			log.LogTender.Errorf("headerRelay.dropCorrupt - DeleteCosmosReProve %d error: %s", v.K, err)
+		}
+	}
+}
+
+func (this *headerRelay) handle(hdrs []*mcosmos.CosmosHeader, height int64) {
+	if !this.retry() {
+		this.save(hdrs, height)
+		return
+	}
+	if err := handleCosmosHdrs(hdrs); err != nil {
+		log.LogTender.Errorf("headerRelay.handle - handleCosmosHdrs, height: %d, lenth: %d, err: %s", height, len(hdrs), err)
+		this.save(hdrs, height)
+		return
+	}
+	this.checkpoint(height)
+}
+
+// retry relays the failed batches, it returns true once none is left
+func (this *headerRelay) retry() bool {
+	if !this.pending {
+		return true
+	}
+	if time.Since(this.lastRetry) < HEIMDALL_REPROVE_INTERVAL {
+		return false
+	}
+	this.lastRetry = time.Now()
+
+	batches, err := ctx.Db.GetAllCosmosReProve()
+	if err != nil {
+		log.LogTender.Errorf("headerRelay.retry - GetAllCosmosReProve error: %s", err)
+		return false
+	}
+	metrics.Set(METRIC_HEIMDALL_REPROVE_PENDING, int64(len(batches)))
+	for _, v := range batches {
+		batch, hdrs, err := decodeCosmosReProve(v.V)
+		if err != nil {
+			// the headers may hold an epoch switch, nothing after them can be relayed
+			metrics.Alert(ALERT_HEIMDALL_REPROVE_CORRUPT, "saved batch %d cannot be decoded, "+
+				"restart the relayer to fetch its headers again: %s", v.K, err)
+			return false
+		}
+		if err = handleCosmosHdrs(hdrs); err != nil {
+			log.LogTender.Errorf("headerRelay.retry - batch %d still fails: %s", v.K, err)
+			return false
+		}
+		if err = ctx.Db.DeleteCosmosReProve(int64(v.K)); err != nil {
+			log.LogTender.Errorf("headerRelay.retry - DeleteCosmosReProve %d error: %s", v.K, err)
+			return false
+		}
+		metrics.Add(METRIC_HEIMDALL_REPROVE_PENDING, -1)
+		log.LogTender.Infof("headerRelay.retry - batch %d of %d headers is confirmed", v.K, len(hdrs))
+		this.checkpoint(batch.Height)
+	}
+
+	metrics.Resolve(ALERT_HEIMDALL_REPROVE_CORRUPT)
+	this.pending = false
+	if this.deferred > 0 {
+		this.checkpoint(this.deferred)
+		this.deferred = 0
+	}
+	return true
+}
+
+// save keeps a failed batch, or one received while failed batches are pending
+func (this *headerRelay) save(hdrs []*mcosmos.CosmosHeader, height int64) {
+	if !this.pending {
+		this.pending = true
+		this.lastRetry = time.Now()
+	}
+	if len(hdrs) == 0 {
+		if height > this.deferred {
+			this.deferred = height
+		}
+		return
+	}
+
+	batch := &cosmosReProve{Height: height, Hdrs: make([][]byte, len(hdrs))}
+	for i, hdr := range hdrs {
+		raw, err := ctx.CMCdc.MarshalBinaryBare(*hdr)
+		if err != nil {
+			log.LogTender.Errorf("headerRelay.save - marshal header %d error: %s", hdr.Header.Height, err)
+			return
+		}
+		batch.Hdrs[i] = raw
+	}
+	raw, err := json.Marshal(batch)
+	if err == nil {
+		err = ctx.Db.PutCosmosReProve(height, raw)
+	}
+	if err != nil {
+		// the height is not checkpointed, the headers are fetched again after a restart
+		log.LogTender.Errorf("headerRelay.save - save batch %d error: %s", height, err)
+		return
+	}
+	log.LogTender.Infof("headerRelay.save - batch %d of %d headers saved for retry", height, len(hdrs))
+	metrics.Add(METRIC_HEIMDALL_REPROVE_SAVED, 1)
+	metrics.Add(METRIC_HEIMDALL_REPROVE_PENDING, 1)
+}
+
+func (this *headerRelay) checkpoint(height int64) {
+	if height <= this.height {
+		return
+	}
+	confirmHeimdallHeight(height)
+	if err := ctx.Db.SetCosmosHeight(height); err != nil {
+		log.LogTender.Errorf("headerRelay.checkpoint - failed to update cosmos height: %v", err)
+		return
+	}
+	this.height = height
+	// every header up to height is on Poly now
+	metrics.Resolve(ALERT_HEIMDALL_HEADER_REJECTED)
+}
+
+func decodeCosmosReProve(raw []byte) (*cosmosReProve, []*mcosmos.CosmosHeader, error) {
+	batch := &cosmosReProve{}
+	if err := json.Unmarshal(raw, batch); err != nil {
+		return nil, nil, err
+	}
+	hdrs := make([]*mcosmos.CosmosHeader, len(batch.Hdrs))
+	for i, v := range batch.Hdrs {
+		hdr := &mcosmos.CosmosHeader{}
+		if err := ctx.CMCdc.UnmarshalBinaryBare(v, hdr); err != nil {
+			return nil, nil, fmt.Errorf("unmarshal header %d: %s", i, err)
+		}
+		hdrs[i] = hdr
+	}
+	return batch, hdrs, nil
+}
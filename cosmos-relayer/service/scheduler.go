@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+package service
+
+import (
+	"sync"
+
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
+)
+
+// heimdallSchedule orders heimdall and bor headers on Poly. A bor sprint-end
+// header carries a span proof checked against a heimdall header, so it can only
+// be committed once every heimdall epoch switch header up to that height is
+// confirmed on Poly. Bor header sync asks for the heimdall height it needs and
+// holds the header until it is confirmed, the listener flushes its batch as
+// soon as it reaches that height.
+type heimdallSchedule struct {
+	mu        sync.Mutex
+	confirmed int64 // heimdall headers up to this height are confirmed on Poly
+	wanted    int64 // heimdall height a held bor header waits for
+}
+
+var schedule = &heimdallSchedule{}
+
+// ConfirmedHeimdallHeight returns the heimdall height whose validators Poly knows
+func ConfirmedHeimdallHeight() int64 {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+
+	return schedule.confirmed
+}
+
+// RequireHeimdallHeight asks the listener to get heimdall height h confirmed on
+// Poly first. It returns whether h is confirmed already.
+func RequireHeimdallHeight(h int64) bool {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+
+	if h <= schedule.confirmed {
+		return true
+	}
+	if h > schedule.wanted {
+		log.LogTender.Infof("RequireHeimdallHeight - bor header sync waits for heimdall height %d, confirmed: %d", h, schedule.confirmed)
+		schedule.wanted = h
+	}
+	return false
+}
+
+func wantedHeimdallHeight() int64 {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+
+	if schedule.wanted <= schedule.confirmed {
+		return 0
+	}
+	return schedule.wanted
+}
+
+func confirmHeimdallHeight(h int64) {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+
+	if h > schedule.confirmed {
+		schedule.confirmed = h
+		metrics.Set(METRIC_HEIMDALL_CONFIRMED_HEIGHT, h)
+	}
+	if schedule.wanted != 0 && schedule.wanted <= h {
+		log.LogTender.Infof("confirmHeimdallHeight - heimdall height %d wanted by bor header sync is confirmed", schedule.wanted)
+		schedule.wanted = 0
+	}
+}
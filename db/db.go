@@ -19,18 +19,27 @@ package db
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/polynetwork/polygon-relayer/tools"
 )
 
-const MAX_NUM = 1000
+const (
+	MAX_NUM = 1000
+
+	BOLT_OPEN_TIMEOUT = 10 * time.Second // waiting for another process holding the db
+
+	DEAD_LETTER_POLY = "poly" // poly to bor transfer, from BKTBridgeTransactions
+	DEAD_LETTER_BOR  = "bor"  // bor to poly transfer, from BKTRetry
+)
 
 var (
 	BKTCheck  = []byte("Check")
@@ -38,8 +47,14 @@ var (
 	BKTHeight = []byte("Height")
 
 	BKTBridgeTransactions = []byte("Bridge Transactions")
+	BKTDeadLetter         = []byte("DeadLetter") // direction:id => json of a DeadLetter
+	BKTHeld               = []byte("Held")       // direction:id => json of a DeadLetter waiting for approval
 
-	BKTSpan = []byte("Span") //bor block height => spanId, span data
+	BKTSpan      = []byte("Span")      //bor block height => spanId, span data
+	BKTSpanData  = []byte("SpanData")  // spanId => json of the full heimdall span
+	BKTSpanProof = []byte("SpanProof") // heimdall height + spanId => span query response with proof
+
+	BKTTopUp = []byte("TopUp") // time + sender address => top-up record
 
 	// tendermint
 	PolyState       = []byte("poly")
@@ -66,7 +81,7 @@ func NewBoltDB(filePath string) (*BoltDB, error) {
 		filePath = path.Join(filePath, "bolt.bin")
 	}
 	w := new(BoltDB)
-	db, err := bolt.Open(filePath, 0644, &bolt.Options{InitialMmapSize: 500000})
+	db, err := bolt.Open(filePath, 0644, &bolt.Options{InitialMmapSize: 500000, Timeout: BOLT_OPEN_TIMEOUT})
 	if err != nil {
 		return nil, err
 	}
@@ -118,6 +133,28 @@ func NewBoltDB(filePath string) (*BoltDB, error) {
 		return nil, err
 	}
 
+	if err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(BKTSpanData)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(BKTSpanProof)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
 	// tendermint
 	if err = db.Update(func(btx *bolt.Tx) error {
 		_, err := btx.CreateBucketIfNotExists(PolyState)
@@ -196,6 +233,39 @@ func NewBoltDB(filePath string) (*BoltDB, error) {
 		return nil, err
 	}
 
+	if err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(BKTDeadLetter)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(BKTHeld)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(BKTTopUp)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
 	return w, nil
 }
 
@@ -429,6 +499,93 @@ func (w *BoltDB) UpdatePolyHeight(h uint32) error {
 	})
 }
 
+// CommitPolyBlock stores the bridge transactions found in poly block h,
+// txHash => v, the dead letters and held transfers of the block, and moves the
+// poly height to h in one transaction, so a block is either fully handled or
+// handled again
+func (w *BoltDB) CommitPolyBlock(h uint32, txs map[string][]byte, letters []*DeadLetter, held []*DeadLetter) error {
+	rawLetters, err := marshalLetters(letters)
+	if err != nil {
+		return err
+	}
+	rawHeld, err := marshalLetters(held)
+	if err != nil {
+		return err
+	}
+
+	w.rwlock.Lock()
+	defer w.rwlock.Unlock()
+
+	raw := make([]byte, 4)
+	binary.LittleEndian.PutUint32(raw, h)
+
+	return w.db.Update(func(btx *bolt.Tx) error {
+		for bucket, kvs := range map[string]map[string][]byte{
+			string(BKTBridgeTransactions): txs,
+			string(BKTDeadLetter):         rawLetters,
+			string(BKTHeld):               rawHeld,
+		} {
+			bkt := btx.Bucket([]byte(bucket))
+			for k, v := range kvs {
+				if err := bkt.Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+		}
+		return btx.Bucket(BKTHeight).Put([]byte("poly_height"), raw)
+	})
+}
+
+// CommitBorBlock stores the retry keys, the dead letters and the held
+// transfers found in a bor block in one transaction
+func (w *BoltDB) CommitBorBlock(retries [][]byte, letters []*DeadLetter, held []*DeadLetter) error {
+	rawLetters, err := marshalLetters(letters)
+	if err != nil {
+		return err
+	}
+	rawHeld, err := marshalLetters(held)
+	if err != nil {
+		return err
+	}
+
+	w.rwlock.Lock()
+	defer w.rwlock.Unlock()
+
+	return w.db.Update(func(btx *bolt.Tx) error {
+		bkt := btx.Bucket(BKTRetry)
+		for _, k := range retries {
+			if err := bkt.Put(k, []byte{0x00}); err != nil {
+				return err
+			}
+		}
+		for bucket, kvs := range map[string]map[string][]byte{
+			string(BKTDeadLetter): rawLetters,
+			string(BKTHeld):       rawHeld,
+		} {
+			bkt := btx.Bucket([]byte(bucket))
+			for k, v := range kvs {
+				if err := bkt.Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// marshalLetters maps the db key of each letter to its json
+func marshalLetters(letters []*DeadLetter) (map[string][]byte, error) {
+	res := make(map[string][]byte, len(letters))
+	for _, v := range letters {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		res[string(deadLetterKey(v.Direction, v.ID))] = raw
+	}
+	return res, nil
+}
+
 func (w *BoltDB) GetPolyHeight() uint32 {
 	w.rwlock.RLock()
 	defer w.rwlock.RUnlock()
@@ -543,3 +700,287 @@ func (w *BoltDB) GetAllBridgeTransactions() (map[string][]byte, error) {
 	}
 	return checkMap, nil
 }
+
+// PutTopUp stores a top-up record, the key starts with the big endian unix nano
+// time so records are kept in time order.
+func (w *BoltDB) PutTopUp(k []byte, v []byte) error {
+	w.rwlock.Lock()
+	defer w.rwlock.Unlock()
+
+	return w.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(BKTTopUp)
+		err := bucket.Put(k, v)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// GetTopUpsSince returns all top-up records written at or after unix nano time since.
+func (w *BoltDB) GetTopUpsSince(since uint64) ([][]byte, error) {
+	w.rwlock.RLock()
+	defer w.rwlock.RUnlock()
+
+	records := make([][]byte, 0)
+	err := w.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(BKTTopUp).Cursor()
+		for k, v := c.Seek(tools.Uint64ToBigEndian(since)); k != nil; k, v = c.Next() {
+			_v := make([]byte, len(v))
+			copy(_v, v)
+			records = append(records, _v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func spanProofKey(heimHeight uint64, spanId uint64) []byte {
+	return append(tools.Uint64ToBigEndian(heimHeight), tools.Uint64ToBigEndian(spanId)...)
+}
+
+// PutSpanProof stores the raw span query response of spanId at heimdall height heimHeight
+func (w *BoltDB) PutSpanProof(heimHeight uint64, spanId uint64, v []byte) error {
+	w.rwlock.Lock()
+	defer w.rwlock.Unlock()
+
+	return w.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(BKTSpanProof)
+		err := bucket.Put(spanProofKey(heimHeight, spanId), v)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (w *BoltDB) GetSpanProof(heimHeight uint64, spanId uint64) []byte {
+	w.rwlock.RLock()
+	defer w.rwlock.RUnlock()
+
+	var v []byte
+	_ = w.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(BKTSpanProof).Get(spanProofKey(heimHeight, spanId))
+		if len(raw) == 0 {
+			return nil
+		}
+		v = make([]byte, len(raw))
+		copy(v, raw)
+		return nil
+	})
+	return v
+}
+
+// PutCosmosReProve stores a heimdall header batch that failed to be confirmed
+// on Poly, keyed by the heimdall height checkpointed once it is confirmed.
+func (w *BoltDB) PutCosmosReProve(height int64, v []byte) error {
+	w.rwlock.Lock()
+	defer w.rwlock.Unlock()
+
+	return w.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(CosmosReProve)
+		err := bucket.Put(tools.Uint64ToBigEndian(uint64(height)), v)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (w *BoltDB) DeleteCosmosReProve(height int64) error {
+	w.rwlock.Lock()
+	defer w.rwlock.Unlock()
+
+	return w.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(CosmosReProve)
+		err := bucket.Delete(tools.Uint64ToBigEndian(uint64(height)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// GetAllCosmosReProve returns the failed heimdall header batches in height order
+func (w *BoltDB) GetAllCosmosReProve() ([]*KeyValue, error) {
+	w.rwlock.RLock()
+	defer w.rwlock.RUnlock()
+
+	batches := make([]*KeyValue, 0)
+	err := w.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(CosmosReProve).ForEach(func(k, v []byte) error {
+			_v := make([]byte, len(v))
+			copy(_v, v)
+			batches = append(batches, &KeyValue{
+				K: tools.BigEndianToUint64(k),
+				V: _v,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// LoadBridgeTransactions returns every stored bridge transaction
+func (w *BoltDB) LoadBridgeTransactions() (map[string][]byte, error) {
+	w.rwlock.RLock()
+	defer w.rwlock.RUnlock()
+
+	res := make(map[string][]byte)
+	err := w.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(BKTBridgeTransactions).ForEach(func(k, v []byte) error {
+			_v := make([]byte, len(v))
+			copy(_v, v)
+			res[string(k)] = _v
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DeadLetter is a transfer the relayer gave up on, kept until it is replayed,
+// or a transfer held until it is approved
+type DeadLetter struct {
+	Direction string // DEAD_LETTER_POLY or DEAD_LETTER_BOR
+	ID        string
+	Key       []byte // key in the bucket it came from
+	Reason    string
+	Time      int64 // unix time it was dead-lettered or held
+	Payload   []byte
+}
+
+func deadLetterKey(direction string, id string) []byte {
+	return []byte(direction + ":" + id)
+}
+
+// MoveToDeadLetter deletes letter.Key from bucket and keeps letter in
+// BKTDeadLetter, in one transaction
+func (w *BoltDB) MoveToDeadLetter(bucket []byte, letter *DeadLetter) error {
+	return w.moveLetter(bucket, letter.Key, BKTDeadLetter, letter)
+}
+
+// GetAllDeadLetters returns the dead letters ordered by direction and id
+func (w *BoltDB) GetAllDeadLetters() ([]*DeadLetter, error) {
+	return w.getAllLetters(BKTDeadLetter)
+}
+
+// GetDeadLetter returns dead letter direction:id, nil if there is none
+func (w *BoltDB) GetDeadLetter(direction string, id string) (*DeadLetter, error) {
+	return w.getLetter(BKTDeadLetter, direction, id)
+}
+
+// RestoreDeadLetter puts k, v back to bucket and deletes the dead letter, in
+// one transaction
+func (w *BoltDB) RestoreDeadLetter(letter *DeadLetter, bucket []byte, k []byte, v []byte) error {
+	return w.restoreLetter(BKTDeadLetter, letter, bucket, k, v)
+}
+
+// MoveToHeld deletes letter.Key from bucket and keeps letter in BKTHeld until
+// it is approved or dropped, in one transaction
+func (w *BoltDB) MoveToHeld(bucket []byte, letter *DeadLetter) error {
+	return w.moveLetter(bucket, letter.Key, BKTHeld, letter)
+}
+
+// GetAllHeld returns the held transfers ordered by direction and id
+func (w *BoltDB) GetAllHeld() ([]*DeadLetter, error) {
+	return w.getAllLetters(BKTHeld)
+}
+
+// GetHeld returns held transfer direction:id, nil if there is none
+func (w *BoltDB) GetHeld(direction string, id string) (*DeadLetter, error) {
+	return w.getLetter(BKTHeld, direction, id)
+}
+
+// ReleaseHeld puts k, v back to bucket and deletes the held transfer, in one
+// transaction
+func (w *BoltDB) ReleaseHeld(letter *DeadLetter, bucket []byte, k []byte, v []byte) error {
+	return w.restoreLetter(BKTHeld, letter, bucket, k, v)
+}
+
+// DropHeld moves a held transfer to BKTDeadLetter with reason
+func (w *BoltDB) DropHeld(letter *DeadLetter, reason string) error {
+	dropped := *letter
+	dropped.Reason = reason
+	return w.moveLetter(BKTHeld, deadLetterKey(letter.Direction, letter.ID), BKTDeadLetter, &dropped)
+}
+
+func (w *BoltDB) moveLetter(from []byte, k []byte, to []byte, letter *DeadLetter) error {
+	raw, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	w.rwlock.Lock()
+	defer w.rwlock.Unlock()
+
+	return w.db.Update(func(btx *bolt.Tx) error {
+		if err := btx.Bucket(from).Delete(k); err != nil {
+			return err
+		}
+		return btx.Bucket(to).Put(deadLetterKey(letter.Direction, letter.ID), raw)
+	})
+}
+
+func (w *BoltDB) getAllLetters(bucket []byte) ([]*DeadLetter, error) {
+	w.rwlock.RLock()
+	defer w.rwlock.RUnlock()
+
+	letters := make([]*DeadLetter, 0)
+	err := w.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			letter := new(DeadLetter)
+			if err := json.Unmarshal(v, letter); err != nil {
+				return fmt.Errorf("%s %s: %s", string(bucket), string(k), err)
+			}
+			letters = append(letters, letter)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+func (w *BoltDB) getLetter(bucket []byte, direction string, id string) (*DeadLetter, error) {
+	w.rwlock.RLock()
+	defer w.rwlock.RUnlock()
+
+	var letter *DeadLetter
+	err := w.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucket).Get(deadLetterKey(direction, id))
+		if raw == nil {
+			return nil
+		}
+		letter = new(DeadLetter)
+		return json.Unmarshal(raw, letter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+func (w *BoltDB) restoreLetter(from []byte, letter *DeadLetter, bucket []byte, k []byte, v []byte) error {
+	w.rwlock.Lock()
+	defer w.rwlock.Unlock()
+
+	return w.db.Update(func(btx *bolt.Tx) error {
+		if err := btx.Bucket(bucket).Put(k, v); err != nil {
+			return err
+		}
+		return btx.Bucket(from).Delete(deadLetterKey(letter.Direction, letter.ID))
+	})
+}
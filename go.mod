@@ -3,6 +3,7 @@ module github.com/polynetwork/polygon-relayer
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/boltdb/bolt v1.3.1
 	github.com/btcsuite/btcd v0.21.0-beta
 	github.com/christianxiao/tendermint v0.26.0-polygon
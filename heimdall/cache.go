@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package heimdall
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache keeps the last size values put, it only holds data that can not
+// change, such as a commit or a proven query at a given height.
+type cache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // keys, oldest first
+	items map[string]interface{}
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]interface{}),
+	}
+}
+
+func (this *cache) get(key string) (interface{}, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	v, ok := this.items[key]
+	return v, ok
+}
+
+func (this *cache) put(key string, v interface{}) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if _, ok := this.items[key]; !ok {
+		this.order.PushBack(key)
+	}
+	this.items[key] = v
+	for this.order.Len() > this.size {
+		oldest := this.order.Remove(this.order.Front()).(string)
+		delete(this.items, oldest)
+	}
+}
@@ -0,0 +1,324 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package heimdall is a client of the heimdall rpc, shared by the heimdall
+// header relay and the bor header sync. Calls failing on the transport are
+// retried, data fixed at a height is cached.
+package heimdall
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	abcitypes "github.com/christianxiao/tendermint/abci/types"
+	cryptoamino "github.com/christianxiao/tendermint/crypto/encoding/amino"
+	rpcclient "github.com/christianxiao/tendermint/rpc/client"
+	rpctypes "github.com/christianxiao/tendermint/rpc/core/types"
+	tdmt_types "github.com/christianxiao/tendermint/types"
+
+	"github.com/polynetwork/polygon-relayer/cosmos-sdk/codec"
+	borTypes "github.com/polynetwork/polygon-relayer/heimdall/bor/types"
+	hmTypes "github.com/polynetwork/polygon-relayer/heimdall/types"
+	"github.com/polynetwork/polygon-relayer/log"
+	mytypes "github.com/polynetwork/polygon-relayer/types"
+)
+
+const (
+	DEFAULT_RETRIES    = 3
+	DEFAULT_RETRY_WAIT = time.Second // doubled after each retry
+	DEFAULT_CACHE_SIZE = 1024        // entries of each cache
+
+	SPAN_PREFIX_KEY = 0x36 // prefix of the span keys in the bor store
+	SPAN_STORE_PATH = "/store/bor/key"
+	BOR_QUERY_PATH  = "custom/bor/"
+)
+
+// GetSpanKey returns the key of span id in the bor store
+func GetSpanKey(id uint64) []byte {
+	return append([]byte{SPAN_PREFIX_KEY}, []byte(strconv.FormatUint(id, 10))...)
+}
+
+// BorParams are the params of the heimdall bor module
+type BorParams struct {
+	SprintDuration uint64 `json:"sprint_duration"`
+	SpanDuration   uint64 `json:"span_duration"`
+	ProducerCount  uint64 `json:"producer_count"`
+}
+
+type Client struct {
+	rpc *rpcclient.HTTP
+	cdc *codec.Codec
+
+	retries   int
+	retryWait time.Duration
+
+	commits    *cache // height => *rpctypes.ResultCommit
+	validators *cache // height => []*tdmt_types.Validator
+	spans      *cache // span id and height => *spanWithProof
+}
+
+type spanWithProof struct {
+	res  *abcitypes.ResponseQuery
+	span *hmTypes.Span
+}
+
+// NewClient returns a client of the heimdall rpc at addr
+func NewClient(addr string) *Client {
+	cdc := codec.New()
+	cryptoamino.RegisterAmino(cdc)
+	return NewClientWithRPC(rpcclient.NewHTTP(addr, "/websocket"), cdc)
+}
+
+// NewClientWithRPC returns a client using rpc, and cdc to decode the amino
+// encoded heimdall values
+func NewClientWithRPC(rpc *rpcclient.HTTP, cdc *codec.Codec) *Client {
+	return &Client{
+		rpc:        rpc,
+		cdc:        cdc,
+		retries:    DEFAULT_RETRIES,
+		retryWait:  DEFAULT_RETRY_WAIT,
+		commits:    newCache(DEFAULT_CACHE_SIZE),
+		validators: newCache(DEFAULT_CACHE_SIZE),
+		spans:      newCache(DEFAULT_CACHE_SIZE),
+	}
+}
+
+// SetRetry sets how many times a call failing on the transport is retried,
+// waiting wait before the first retry and twice as long before each next one
+func (this *Client) SetRetry(retries int, wait time.Duration) {
+	this.retries = retries
+	this.retryWait = wait
+}
+
+func (this *Client) Codec() *codec.Codec {
+	return this.cdc
+}
+
+func (this *Client) RPC() *rpcclient.HTTP {
+	return this.rpc
+}
+
+// call runs fn until it succeeds or returns a *QueryError, at most retries + 1
+// times. It returns an *RPCError once the retries are used up.
+func (this *Client) call(method string, height int64, fn func() error) error {
+	wait := this.retryWait
+	var err error
+	for i := 0; i <= this.retries; i++ {
+		if i > 0 {
+			log.Debugf("heimdall.Client - retry %s at %d after %s: %s", method, height, wait, err)
+			time.Sleep(wait)
+			wait *= 2
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+		if _, ok := err.(*QueryError); ok {
+			return err
+		}
+	}
+	return &RPCError{Method: method, Height: height, Err: err}
+}
+
+// query runs an abci query at height, 0 means latest
+func (this *Client) query(path string, data []byte, height int64, prove bool) (*abcitypes.ResponseQuery, error) {
+	var res *rpctypes.ResultABCIQuery
+	err := this.call("abci_query "+path, height, func() error {
+		var err error
+		res, err = this.rpc.ABCIQueryWithOptions(path, data, rpcclient.ABCIQueryOptions{Prove: prove, Height: height})
+		if err != nil {
+			return err
+		}
+		if res.Response.Code != 0 {
+			return &QueryError{Path: path, Height: height, Code: res.Response.Code, Log: res.Response.Log}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &res.Response, nil
+}
+
+func (this *Client) Status() (*rpctypes.ResultStatus, error) {
+	var status *rpctypes.ResultStatus
+	err := this.call("status", 0, func() error {
+		var err error
+		status, err = this.rpc.Status()
+		return err
+	})
+	return status, err
+}
+
+func (this *Client) LatestHeight() (int64, error) {
+	status, err := this.Status()
+	if err != nil {
+		return 0, err
+	}
+	return status.SyncInfo.LatestBlockHeight, nil
+}
+
+// Commit returns the signed header at height h
+func (this *Client) Commit(h int64) (*rpctypes.ResultCommit, error) {
+	key := strconv.FormatInt(h, 10)
+	if v, ok := this.commits.get(key); ok {
+		return v.(*rpctypes.ResultCommit), nil
+	}
+	var rc *rpctypes.ResultCommit
+	err := this.call("commit", h, func() error {
+		var err error
+		rc, err = this.rpc.Commit(&h)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the commit of the latest height may still change
+	if rc.CanonicalCommit {
+		this.commits.put(key, rc)
+	}
+	return rc, nil
+}
+
+// ValidatorSet returns the validators at height h
+// TODO: this only return first 100 items
+func (this *Client) ValidatorSet(h int64) ([]*tdmt_types.Validator, error) {
+	key := strconv.FormatInt(h, 10)
+	if v, ok := this.validators.get(key); ok {
+		return v.([]*tdmt_types.Validator), nil
+	}
+	vSet := make([]*tdmt_types.Validator, 0)
+	err := this.call("validators", h, func() error {
+		res, err := this.rpc.Validators(&h)
+		if err != nil {
+			if strings.Contains(err.Error(), "page should be within") {
+				return nil
+			}
+			return err
+		}
+		// In case tendermint don't give relayer the right error
+		vSet = append(vSet[:0], res.Validators...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("heimdall.Client.ValidatorSet - height: %d, validators: %d", h, len(vSet))
+	this.validators.put(key, vSet)
+	return vSet, nil
+}
+
+// CosmosHeader returns the header at height h with its commit and validators,
+// as synced to Poly
+func (this *Client) CosmosHeader(h int64) (*mytypes.CosmosHeader, error) {
+	rc, err := this.Commit(h)
+	if err != nil {
+		return nil, err
+	}
+	vSet, err := this.ValidatorSet(h)
+	if err != nil {
+		return nil, err
+	}
+	return &mytypes.CosmosHeader{
+		Header:  *rc.Header,
+		Commit:  rc.Commit,
+		Valsets: vSet,
+	}, nil
+}
+
+// LatestSpan returns the latest span at height h, 0 means latest
+func (this *Client) LatestSpan(h int64) (*hmTypes.Span, error) {
+	res, err := this.query(BOR_QUERY_PATH+borTypes.QueryLatestSpan, nil, h, true)
+	if err != nil {
+		return nil, err
+	}
+	span := new(hmTypes.Span)
+	if err = json.Unmarshal(res.Value, span); err != nil {
+		return nil, fmt.Errorf("heimdall.Client.LatestSpan - unmarshal failed, height %d: %s", h, err)
+	}
+	return span, nil
+}
+
+// NextSpan returns the span heimdall would propose next as span id, at height h
+func (this *Client) NextSpan(id uint64, h int64) (*hmTypes.Span, error) {
+	params, err := json.Marshal(borTypes.NewQuerySpanParams(id))
+	if err != nil {
+		return nil, err
+	}
+	path := BOR_QUERY_PATH + borTypes.QueryNextSpan
+	res, err := this.query(path, params, h, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Value) == 0 {
+		return nil, &QueryError{Path: path, Height: h, Code: res.Code, Log: res.Log}
+	}
+	span := new(hmTypes.Span)
+	if err = json.Unmarshal(res.Value, span); err != nil {
+		return nil, fmt.Errorf("heimdall.Client.NextSpan - unmarshal failed, id %d, height %d: %s", id, h, err)
+	}
+	return span, nil
+}
+
+// SpanWithProof returns span id with the query response proving it at height
+// h, 0 means latest. It returns an error wrapping ErrSpanNotFound if the span
+// is too new for h.
+func (this *Client) SpanWithProof(id uint64, h int64) (*abcitypes.ResponseQuery, *hmTypes.Span, error) {
+	key := fmt.Sprintf("%d-%d", id, h)
+	if h > 0 {
+		if v, ok := this.spans.get(key); ok {
+			sp := v.(*spanWithProof)
+			return sp.res, sp.span, nil
+		}
+	}
+
+	res, err := this.query(SPAN_STORE_PATH, GetSpanKey(id), h, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The spanID is too new in old heimdall height
+	if len(res.Value) == 0 || len(res.Key) == 0 {
+		return nil, nil, fmt.Errorf("heimdall.Client.SpanWithProof - span %d is too new at height %d: %w", id, h, ErrSpanNotFound)
+	}
+	if res.Proof == nil || len(res.Proof.GetOps()) == 0 {
+		return nil, nil, fmt.Errorf("heimdall.Client.SpanWithProof - span %d at height %d: %w: %s", id, h, ErrSpanNotFound, ErrNoProof)
+	}
+
+	span := new(hmTypes.Span)
+	if err = this.cdc.UnmarshalBinaryBare(res.Value, span); err != nil {
+		return nil, nil, fmt.Errorf("heimdall.Client.SpanWithProof - unmarshal failed, id %d, height %d: %s", id, h, err)
+	}
+	if h > 0 {
+		this.spans.put(key, &spanWithProof{res: res, span: span})
+	}
+	return res, span, nil
+}
+
+// Params returns the bor module params at height h, 0 means latest
+func (this *Client) Params(h int64) (*BorParams, error) {
+	res, err := this.query(BOR_QUERY_PATH+borTypes.QueryParams, nil, h, false)
+	if err != nil {
+		return nil, err
+	}
+	params := new(BorParams)
+	if err = json.Unmarshal(res.Value, params); err != nil {
+		return nil, fmt.Errorf("heimdall.Client.Params - unmarshal failed, height %d: %s", h, err)
+	}
+	return params, nil
+}
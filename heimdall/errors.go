@@ -0,0 +1,59 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package heimdall
+
+import (
+	"errors"
+	"fmt"
+
+	mytypes "github.com/polynetwork/polygon-relayer/types"
+)
+
+// ErrSpanNotFound is returned when heimdall does not know a span at the
+// height asked, e.g. a span too new for an old height. It is types.ErrSpanNotFound.
+var ErrSpanNotFound = mytypes.ErrSpanNotFound
+
+// ErrNoProof is returned when heimdall answers a proven query without a proof
+var ErrNoProof = errors.New("heimdall response has no proof")
+
+// RPCError is a heimdall rpc call that still failed after the retries
+type RPCError struct {
+	Method string
+	Height int64
+	Err    error
+}
+
+func (this *RPCError) Error() string {
+	return fmt.Sprintf("heimdall %s at height %d: %s", this.Method, this.Height, this.Err)
+}
+
+func (this *RPCError) Unwrap() error {
+	return this.Err
+}
+
+// QueryError is an abci query heimdall answered with an error, it is not retried
+type QueryError struct {
+	Path   string
+	Height int64
+	Code   uint32
+	Log    string
+}
+
+func (this *QueryError) Error() string {
+	return fmt.Sprintf("heimdall query %s at height %d failed, code %d: %s", this.Path, this.Height, this.Code, this.Log)
+}
@@ -28,7 +28,7 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 
 	sdk "github.com/polynetwork/poly-go-sdk"
-	"github.com/polynetwork/polygon-relayer/cosmos-sdk/codec"
+	"github.com/polynetwork/polygon-relayer/heimdall"
 	"github.com/polynetwork/polygon-relayer/manager"
 	sdkp "github.com/polynetwork/polygon-relayer/poly_go_sdk"
 
@@ -38,10 +38,9 @@ import (
 	"github.com/polynetwork/polygon-relayer/cosmos-relayer/service"
 	"github.com/polynetwork/polygon-relayer/db"
 	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
 
 	"github.com/polynetwork/polygon-relayer/global"
-
-	rpcclient "github.com/christianxiao/tendermint/rpc/client"
 )
 
 var ConfigPath string
@@ -59,6 +58,7 @@ func setupApp() *cli.App {
 	app.Flags = []cli.Flag{
 		cmd.LogLevelFlag,
 		cmd.ConfigPathFlag,
+		cmd.ConfigSetFlag,
 		cmd.EthStartFlag,
 		cmd.EthStartForceFlag,
 		cmd.PolyStartFlag,
@@ -66,7 +66,12 @@ func setupApp() *cli.App {
 		cmd.TestLocalFlag,
 		cmd.NofeemodeFlag,
 	}
-	app.Commands = []cli.Command{}
+	app.Commands = []cli.Command{
+		cmd.SecretsCommand,
+		cmd.ConfigCommand,
+		cmd.DeadLetterCommand,
+		cmd.HeldCommand,
+	}
 	app.Before = func(context *cli.Context) error {
 		runtime.GOMAXPROCS(runtime.NumCPU())
 		return nil
@@ -101,21 +106,39 @@ func startServer(ctx *cli.Context) {
 	nofeemode := ctx.GlobalBool(cmd.GetFlagName(cmd.NofeemodeFlag))
 
 	// read config
-	servConfig := config.NewServiceConfig(ConfigPath)
-	if servConfig == nil {
-		log.Errorf("startServer - create config failed!")
+	servConfig, err := config.NewServiceConfig(ConfigPath, ctx.GlobalStringSlice(cmd.GetFlagName(cmd.ConfigSetFlag))...)
+	if err != nil {
+		log.Errorf("startServer - create config failed: %s", err)
 		return
 	}
 
 	global.ServiceConfig = servConfig
 
+	contracts, err := manager.NewContractMatcher(servConfig)
+	if err != nil {
+		log.Errorf("startServer - load contract rules failed: %s", err)
+		return
+	}
+	go contracts.Watch()
+	metrics.Handle("/contracts", contracts)
+	valueCaps := manager.NewValueCaps(servConfig.ValueCaps)
+
+	metrics.SetAlertWebhook(servConfig.AlertWebhook)
+	if servConfig.AdminAddr != "" {
+		go func() {
+			if err := metrics.Serve(servConfig.AdminAddr, servConfig.AdminToken); err != nil {
+				log.Errorf("startServer - admin server error: %s", err)
+			}
+		}()
+	}
+
 	if servConfig.ETHConfig.StartHeight > 0 {
 		StartHeight = servConfig.ETHConfig.StartHeight
 	}
 
 	// create poly sdk
 	polySdk := sdk.NewPolySdk()
-	err := setUpPoly(polySdk, servConfig.PolyConfig.RestURL)
+	err = setUpPoly(polySdk, servConfig.PolyConfig.RestURL)
 	if err != nil {
 		log.Errorf("startServer - failed to setup poly sdk: %v", err)
 		return
@@ -131,26 +154,21 @@ func startServer(ctx *cli.Context) {
 	}
 	global.Ethereumsdk = ethereumsdk
 
-	var boltDB *db.BoltDB
-	if servConfig.BoltDbPath == "" {
-		boltDB, err = db.NewBoltDB("boltdb")
-	} else {
-		boltDB, err = db.NewBoltDB(servConfig.BoltDbPath)
-	}
+	boltDB, err := db.NewBoltDB(servConfig.BoltDbPath)
 	if err != nil {
 		log.Fatalf("db.NewWaitingDB error:%s", err)
 		return
 	}
 	global.Db = boltDB
 
-	// heimdall client
-	tclient := rpcclient.NewHTTP(servConfig.TendermintConfig.CosmosRpcAddr, "/websocket")
-	tclient.Start()
-	global.Rpcclient = tclient
+	// heimdall client, shared by the heimdall and polygon header sync
+	hclient := heimdall.NewClient(servConfig.TendermintConfig.CosmosRpcAddr)
+	hclient.RPC().Start()
+	global.Rpcclient = hclient.RPC()
 
 	// init heimdall service
 	// all tools and info hold by context object.
-	if err = cosctx.InitCtx(servConfig.TendermintConfig, boltDB, global.PolySdkp, tclient); err != nil {
+	if err = cosctx.InitCtx(servConfig.TendermintConfig, boltDB, global.PolySdkp, hclient); err != nil {
 		log.Fatalf("failed to init context: %v", err)
 		panic(err)
 	}
@@ -158,9 +176,12 @@ func startServer(ctx *cli.Context) {
 	service.StartListen()
 	service.StartRelay()
 
-	initPolyServer(servConfig, global.PolySdkp, ethereumsdk, boltDB, nofeemode)
-	initETHServer(servConfig, global.PolySdkp, ethereumsdk, boltDB, cosctx.RCtx.CMCdc, tclient)
+	treasury := initPolyServer(servConfig, global.PolySdkp, ethereumsdk, boltDB, contracts, valueCaps, nofeemode)
+	initETHServer(servConfig, global.PolySdkp, ethereumsdk, boltDB, hclient, contracts, valueCaps)
 	waitToExit()
+	if treasury != nil {
+		treasury.Stop()
+	}
 }
 
 func setUpPoly(poly *sdk.PolySdk, RpcAddr string) error {
@@ -187,8 +208,8 @@ func waitToExit() {
 	<-exit
 }
 
-func initETHServer(servConfig *config.ServiceConfig, polysdk *sdkp.PolySdk, ethereumsdk *ethclient.Client, boltDB *db.BoltDB, cdc *codec.Codec, tclient *rpcclient.HTTP) {
-	mgr, err := manager.NewEthereumManager(servConfig, StartHeight, StartForceHeight, polysdk, ethereumsdk, boltDB, servConfig.TendermintConfig.CosmosRpcAddr, cdc, tclient)
+func initETHServer(servConfig *config.ServiceConfig, polysdk *sdkp.PolySdk, ethereumsdk *ethclient.Client, boltDB *db.BoltDB, hclient *heimdall.Client, contracts *manager.ContractMatcher, valueCaps *manager.ValueCaps) {
+	mgr, err := manager.NewEthereumManager(servConfig, StartHeight, StartForceHeight, polysdk, ethereumsdk, boltDB, hclient, contracts, valueCaps)
 	if err != nil {
 		log.Error("initETHServer - eth service start err: %s", err.Error())
 		return
@@ -204,14 +225,25 @@ func initETHServer(servConfig *config.ServiceConfig, polysdk *sdkp.PolySdk, ethe
 	go mgr.CheckDeposit()
 }
 
-func initPolyServer(servConfig *config.ServiceConfig, polysdk *sdkp.PolySdk, ethereumsdk *ethclient.Client, boltDB *db.BoltDB, nofeemode bool) {
-	mgr, err := manager.NewPolyManager(servConfig, uint32(PolyStartHeight), polysdk, ethereumsdk, boltDB, nofeemode)
+func initPolyServer(servConfig *config.ServiceConfig, polysdk *sdkp.PolySdk, ethereumsdk *ethclient.Client, boltDB *db.BoltDB, contracts *manager.ContractMatcher, valueCaps *manager.ValueCaps, nofeemode bool) *manager.Treasury {
+	mgr, err := manager.NewPolyManager(servConfig, uint32(PolyStartHeight), polysdk, ethereumsdk, boltDB, contracts, valueCaps, nofeemode)
 	if err != nil {
 		log.Error("initPolyServer - PolyServer service start failed: %v", err)
-		return
+		return nil
 	}
 	go mgr.MonitorChain()
 	go mgr.MonitorDeposit()
+
+	if servConfig.TreasuryConfig != nil {
+		treasury, err := manager.NewTreasury(servConfig, mgr.Senders(), ethereumsdk, boltDB)
+		if err != nil {
+			log.Errorf("initPolyServer - treasury start failed: %v", err)
+			return nil
+		}
+		go treasury.MonitorBalance()
+		return treasury
+	}
+	return nil
 }
 
 func main() {
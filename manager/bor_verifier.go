@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"fmt"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/polynetwork/polygon-relayer/config"
+	"github.com/polynetwork/polygon-relayer/log"
+	mytypes "github.com/polynetwork/polygon-relayer/types"
+)
+
+const (
+	BOR_EXTRA_VANITY = 32 // bytes of signer vanity at the start of the extra data
+	BOR_EXTRA_SEAL   = 65 // bytes of signer seal at the end of the extra data
+)
+
+// BorSealHash returns the hash a bor producer signs, the rlp of the header
+// without the seal at the end of the extra data.
+func BorSealHash(header *ethtypes.Header) (ethcommon.Hash, error) {
+	if len(header.Extra) < BOR_EXTRA_VANITY+BOR_EXTRA_SEAL {
+		return ethcommon.Hash{}, fmt.Errorf("extra data of %d bytes is too short", len(header.Extra))
+	}
+	raw, err := rlp.EncodeToBytes([]interface{}{
+		header.ParentHash,
+		header.UncleHash,
+		header.Coinbase,
+		header.Root,
+		header.TxHash,
+		header.ReceiptHash,
+		header.Bloom,
+		header.Difficulty,
+		header.Number,
+		header.GasLimit,
+		header.GasUsed,
+		header.Time,
+		header.Extra[:len(header.Extra)-BOR_EXTRA_SEAL],
+		header.MixDigest,
+		header.Nonce,
+	})
+	if err != nil {
+		return ethcommon.Hash{}, err
+	}
+	return crypto.Keccak256Hash(raw), nil
+}
+
+// BorEcrecover recovers the producer that sealed a bor header
+func BorEcrecover(header *ethtypes.Header) (ethcommon.Address, error) {
+	hash, err := BorSealHash(header)
+	if err != nil {
+		return ethcommon.Address{}, err
+	}
+	pubkey, err := crypto.Ecrecover(hash.Bytes(), header.Extra[len(header.Extra)-BOR_EXTRA_SEAL:])
+	if err != nil {
+		return ethcommon.Address{}, err
+	}
+	var signer ethcommon.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+	return signer, nil
+}
+
+// BorVerifier checks bor headers against the producers heimdall selected for
+// their span before they are synced to poly: the header must be sealed by a
+// selected producer and it must extend the previously verified header.
+type BorVerifier struct {
+	tclient *TendermintClient
+	mode    string
+
+	lastHash   ethcommon.Hash
+	lastNumber uint64
+
+	// producers of the span being verified
+	span      uint64
+	spanStart uint64
+	spanEnd   uint64
+	producers map[ethcommon.Address]bool
+}
+
+func NewBorVerifier(tclient *TendermintClient, mode string) *BorVerifier {
+	return &BorVerifier{
+		tclient: tclient,
+		mode:    mode,
+	}
+}
+
+// Reset forgets the last verified header, e.g. after the relayer rewinds
+func (this *BorVerifier) Reset() {
+	this.lastHash = ethcommon.Hash{}
+	this.lastNumber = 0
+}
+
+// Verify returns an error wrapping types.ErrInvalidBorHeader if the header
+// must not be synced, or types.ErrSpanNotFound if its span is not known yet.
+func (this *BorVerifier) Verify(header *ethtypes.Header) error {
+	if this.mode == config.PRODUCER_CHECK_OFF {
+		return nil
+	}
+	number := header.Number.Uint64()
+	if this.lastNumber != 0 && number == this.lastNumber+1 && header.ParentHash != this.lastHash {
+		return fmt.Errorf("BorVerifier.Verify - header %d parent %s is not the verified header %s, chain reorganized: %w",
+			number, header.ParentHash.String(), this.lastHash.String(), mytypes.ErrInvalidBorHeader)
+	}
+
+	signer, err := BorEcrecover(header)
+	if err != nil {
+		return fmt.Errorf("BorVerifier.Verify - recover signer of header %d: %s: %w", number, err, mytypes.ErrInvalidBorHeader)
+	}
+
+	producers, err := this.spanProducers(number)
+	if err != nil {
+		return err
+	}
+	if !producers[signer] {
+		return fmt.Errorf("BorVerifier.Verify - header %d is signed by %s, not a producer of span %d: %w",
+			number, signer.String(), this.span, mytypes.ErrInvalidBorHeader)
+	}
+
+	this.lastHash = header.Hash()
+	this.lastNumber = number
+	return nil
+}
+
+// spanProducers returns the selected producers of the span of bor block
+// number. A span is final once it started, so they are kept until a block of
+// another span is verified.
+func (this *BorVerifier) spanProducers(number uint64) (map[ethcommon.Address]bool, error) {
+	if this.producers != nil && number >= this.spanStart && number <= this.spanEnd {
+		return this.producers, nil
+	}
+	// GetSpanIdByBor resolves the span of bor + 1
+	spanId, err := this.tclient.GetSpanIdByBor(number - 1)
+	if err != nil {
+		return nil, err
+	}
+	span, err := this.tclient.GetSpan(spanId)
+	if err != nil {
+		return nil, fmt.Errorf("BorVerifier.spanProducers - get span %d error: %w", spanId, err)
+	}
+	if number < span.StartBlock || number > span.EndBlock {
+		return nil, fmt.Errorf("BorVerifier.spanProducers - header %d is out of span %d, blocks %d to %d: %w",
+			number, span.ID, span.StartBlock, span.EndBlock, mytypes.ErrSpanNotFound)
+	}
+	if len(span.SelectedProducers) == 0 {
+		return nil, fmt.Errorf("BorVerifier.spanProducers - span %d has no selected producers: %w", span.ID, mytypes.ErrInvalidBorHeader)
+	}
+
+	producers := make(map[ethcommon.Address]bool, len(span.SelectedProducers))
+	for _, v := range span.SelectedProducers {
+		producers[ethcommon.BytesToAddress(v.Signer.Bytes())] = true
+	}
+	log.Debugf("BorVerifier.spanProducers - span %d blocks %d to %d, %d producers", span.ID, span.StartBlock, span.EndBlock, len(producers))
+
+	this.span, this.spanStart, this.spanEnd, this.producers = span.ID, span.StartBlock, span.EndBlock, producers
+	return producers, nil
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/polynetwork/polygon-relayer/config"
+	"github.com/polynetwork/polygon-relayer/db"
+	hmTypes "github.com/polynetwork/polygon-relayer/heimdall/types"
+	mytypes "github.com/polynetwork/polygon-relayer/types"
+)
+
+func sealBorHeader(t *testing.T, header *ethtypes.Header, key *ecdsa.PrivateKey) {
+	header.Extra = make([]byte, BOR_EXTRA_VANITY+BOR_EXTRA_SEAL)
+	hash, err := BorSealHash(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := crypto.Sign(hash.Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(header.Extra[BOR_EXTRA_VANITY:], sig)
+}
+
+// newTestSpans returns a client whose db holds span 1 of blocks 0-255 produced
+// by keys, and span 2 after it, so span 1 is final
+func newTestSpans(t *testing.T, keys []*ecdsa.PrivateKey) *TendermintClient {
+	dir, err := ioutil.TempDir("", "bor_verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	boltDB, err := db.NewBoltDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { boltDB.Close() })
+
+	producers := make([]hmTypes.Validator, len(keys))
+	for i, key := range keys {
+		producers[i] = hmTypes.Validator{
+			ID:          hmTypes.ValidatorID(i + 1),
+			VotingPower: 10,
+			Signer:      hmTypes.BytesToHeimdallAddress(crypto.PubkeyToAddress(key.PublicKey).Bytes()),
+		}
+	}
+	tclient := &TendermintClient{db: boltDB, spans: NewSpanIndex()}
+	for _, span := range []*hmTypes.Span{
+		{ID: 1, StartBlock: 0, EndBlock: 255, SelectedProducers: producers},
+		{ID: 2, StartBlock: 256, EndBlock: 6655, SelectedProducers: producers},
+	} {
+		if _, err := tclient.putSpan(span); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tclient
+}
+
+func TestBorVerifierSignerAcrossSprint(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	outsider, _ := crypto.GenerateKey()
+	tclient := newTestSpans(t, keys)
+
+	// blocks 62 to 66 cross the sprint boundary at 64, sealed by producers in
+	// an order unrelated to priorities reset at the span start
+	sealers := []int{2, 2, 0, 1, 1}
+	headers := make([]*ethtypes.Header, len(sealers))
+	parent := ethcommon.Hash{}
+	for i, sealer := range sealers {
+		headers[i] = &ethtypes.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(62 + i)),
+			Difficulty: big.NewInt(1),
+			GasLimit:   20000000,
+			Time:       uint64(1600000000 + 2*i),
+		}
+		sealBorHeader(t, headers[i], keys[sealer])
+		parent = headers[i].Hash()
+	}
+
+	verifier := NewBorVerifier(tclient, config.PRODUCER_CHECK_SIGNER)
+	for _, header := range headers {
+		if err := verifier.Verify(header); err != nil {
+			t.Fatalf("header %d: %s", header.Number.Uint64(), err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		header func() *ethtypes.Header
+	}{
+		{
+			name: "sealed by an outsider",
+			header: func() *ethtypes.Header {
+				h := &ethtypes.Header{ParentHash: parent, Number: big.NewInt(67), Difficulty: big.NewInt(3)}
+				sealBorHeader(t, h, outsider)
+				return h
+			},
+		},
+		{
+			name: "not extending the verified header",
+			header: func() *ethtypes.Header {
+				h := &ethtypes.Header{ParentHash: ethcommon.HexToHash("0x01"), Number: big.NewInt(67), Difficulty: big.NewInt(3)}
+				sealBorHeader(t, h, keys[0])
+				return h
+			},
+		},
+		{
+			name: "without seal",
+			header: func() *ethtypes.Header {
+				return &ethtypes.Header{ParentHash: parent, Number: big.NewInt(67), Difficulty: big.NewInt(3)}
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := verifier.Verify(c.header()); !errors.Is(err, mytypes.ErrInvalidBorHeader) {
+				t.Fatalf("err %v, expect %v", err, mytypes.ErrInvalidBorHeader)
+			}
+		})
+	}
+}
+
+func TestProducerCheckDefault(t *testing.T) {
+	cfg := &config.ServiceConfig{ETHConfig: &config.ETHConfig{}, PolyConfig: &config.PolyConfig{}, TendermintConfig: &config.TendermintConfig{}}
+	cfg.SetDefaults()
+	if cfg.ETHConfig.ProducerCheck != config.PRODUCER_CHECK_SIGNER {
+		t.Fatalf("default ProducerCheck %s, expect %s", cfg.ETHConfig.ProducerCheck, config.PRODUCER_CHECK_SIGNER)
+	}
+}
+
+func TestProducerCheckFullRejected(t *testing.T) {
+	cfg := &config.ServiceConfig{ETHConfig: &config.ETHConfig{ProducerCheck: "full"}, PolyConfig: &config.PolyConfig{}, TendermintConfig: &config.TendermintConfig{}}
+	cfg.SetDefaults()
+	verr, ok := cfg.Validate().(*config.ValidationError)
+	if !ok {
+		t.Fatal("ProducerCheck full accepted")
+	}
+	for _, v := range verr.Errs {
+		if strings.Contains(v, "ProducerCheck") {
+			return
+		}
+	}
+	t.Fatalf("no ProducerCheck error in %v", verr.Errs)
+}
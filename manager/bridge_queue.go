@@ -0,0 +1,325 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/polynetwork/poly/common"
+	"github.com/polynetwork/polygon-relayer/db"
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
+)
+
+// BRIDGE_FEE_AGING is how long a waiting transfer takes to rank like one
+// paying twice its fee, so low-fee transfers eventually go out
+const BRIDGE_FEE_AGING = 10 * time.Minute
+
+func bridgeTransactionKey(tx *BridgeTransaction) string {
+	return fmt.Sprintf("%d%s", tx.param.FromChainID, hex.EncodeToString(tx.param.MakeTxParam.TxHash))
+}
+
+type bridgeQueueItem struct {
+	key   string
+	tx    *BridgeTransaction
+	score float64
+	index int // in the heap, -1 when not ready
+
+	recheckAt time.Time // when the fee of a deferred transfer is checked again
+}
+
+// score orders the ready transfers, it does not change with time: aging adds
+// the same time to every score, so only the enqueue time matters.
+func (this *bridgeQueueItem) updateScore() {
+	fee, _ := new(big.Float).SetString(this.tx.fee)
+	f := 0.0
+	if fee != nil {
+		f, _ = fee.Float64()
+	}
+	if f < 0 {
+		f = 0
+	}
+	this.score = math.Log2(1+f)*BRIDGE_FEE_AGING.Seconds() - float64(this.tx.enqueuedAt)
+}
+
+type bridgeHeap []*bridgeQueueItem
+
+func (h bridgeHeap) Len() int           { return len(h) }
+func (h bridgeHeap) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h bridgeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *bridgeHeap) Push(x interface{}) {
+	item := x.(*bridgeQueueItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *bridgeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	item.index = -1
+	*h = old[:len(old)-1]
+	return item
+}
+
+// BridgeQueue holds the poly to bor transfers waiting to be relayed. It is
+// kept in db.BKTBridgeTransactions, loaded once, then updated as transfers
+// come in, get their fee checked and are sent. Transfers are sent by fee,
+// with aging, see BRIDGE_FEE_AGING.
+type BridgeQueue struct {
+	mu        sync.Mutex
+	db        *db.BoltDB
+	nofeemode bool
+	items     map[string]*bridgeQueueItem
+	ready     bridgeHeap // transfers with their fee paid or free, or all of them in no fee mode
+}
+
+func NewBridgeQueue(boltDB *db.BoltDB, nofeemode bool) (*BridgeQueue, error) {
+	this := &BridgeQueue{
+		db:        boltDB,
+		nofeemode: nofeemode,
+		items:     make(map[string]*bridgeQueueItem),
+		ready:     make(bridgeHeap, 0),
+	}
+	all, err := boltDB.LoadBridgeTransactions()
+	if err != nil {
+		return nil, err
+	}
+	now := uint64(time.Now().Unix())
+	for k, v := range all {
+		tx := new(BridgeTransaction)
+		if err := tx.Deserialization(common.NewZeroCopySource(v)); err != nil {
+			log.Errorf("NewBridgeQueue - deserialize %s error: %s", k, err)
+			continue
+		}
+		// stored before the enqueue time was kept
+		if tx.enqueuedAt == 0 {
+			tx.enqueuedAt = now
+		}
+		this.add(k, tx)
+	}
+	log.Infof("NewBridgeQueue - %d transfers loaded, %d ready", len(this.items), this.ready.Len())
+	return this, nil
+}
+
+func (this *BridgeQueue) add(key string, tx *BridgeTransaction) {
+	if old, ok := this.items[key]; ok && old.index >= 0 {
+		heap.Remove(&this.ready, old.index)
+	}
+	item := &bridgeQueueItem{key: key, tx: tx, index: -1}
+	item.updateScore()
+	this.items[key] = item
+	if tx.hasPay == FEE_HASPAY || tx.hasPay == FEE_FREE || this.nofeemode {
+		heap.Push(&this.ready, item)
+	}
+}
+
+func (this *BridgeQueue) save(key string, tx *BridgeTransaction) error {
+	sink := common.NewZeroCopySink(nil)
+	tx.Serialization(sink)
+	return this.db.PutBridgeTransactions(key, sink.Bytes())
+}
+
+// PushBlock stores the transfers found in poly block height with the poly
+// height, and the transfers of the block dead-lettered or held, see
+// db.CommitPolyBlock. Nothing is queued if it fails.
+func (this *BridgeQueue) PushBlock(height uint32, txs []*BridgeTransaction, letters []*db.DeadLetter, held []*db.DeadLetter) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	now := uint64(time.Now().Unix())
+	keys := make([]string, len(txs))
+	raw := make(map[string][]byte, len(txs))
+	for i, tx := range txs {
+		key := bridgeTransactionKey(tx)
+		if old, ok := this.items[key]; ok {
+			// seen again, e.g. poly blocks handled again after a restart
+			tx.hasPay, tx.fee, tx.enqueuedAt = old.tx.hasPay, old.tx.fee, old.tx.enqueuedAt
+			tx.reason, tx.decidedAt = old.tx.reason, old.tx.decidedAt
+		}
+		if tx.enqueuedAt == 0 {
+			tx.enqueuedAt = now
+		}
+		sink := common.NewZeroCopySink(nil)
+		tx.Serialization(sink)
+		keys[i], raw[key] = key, sink.Bytes()
+	}
+	if err := this.db.CommitPolyBlock(height, raw, letters, held); err != nil {
+		return err
+	}
+	for i, tx := range txs {
+		this.add(keys[i], tx)
+	}
+	return nil
+}
+
+// Unchecked returns the transfers whose fee is not checked yet, and the
+// deferred ones due for another check
+func (this *BridgeQueue) Unchecked() []*BridgeTransaction {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	now := time.Now()
+	res := make([]*BridgeTransaction, 0)
+	for _, v := range this.items {
+		if v.tx.hasPay == FEE_NOCHECK || v.tx.hasPay == FEE_DEFERRED && !now.Before(v.recheckAt) {
+			res = append(res, v.tx)
+		}
+	}
+	return res
+}
+
+// SetFee records the fee check of transfer key
+func (this *BridgeQueue) SetFee(key string, hasPay uint8, fee string, reason string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	tx, ok := this.decided(key, hasPay, fee, reason)
+	if !ok {
+		return nil
+	}
+	if err := this.save(key, tx); err != nil {
+		return err
+	}
+	this.add(key, tx)
+	return nil
+}
+
+// Defer keeps transfer key out of the ready transfers until its fee is
+// checked again at recheckAt
+func (this *BridgeQueue) Defer(key string, fee string, reason string, recheckAt time.Time) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	tx, ok := this.decided(key, FEE_DEFERRED, fee, reason)
+	if !ok {
+		return nil
+	}
+	if err := this.save(key, tx); err != nil {
+		return err
+	}
+	this.add(key, tx)
+	this.items[key].recheckAt = recheckAt
+	return nil
+}
+
+// Reject moves transfer key to the dead letters with the reason
+func (this *BridgeQueue) Reject(key string, fee string, reason string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	tx, ok := this.decided(key, FEE_NOTPAY, fee, reason)
+	if !ok {
+		return nil
+	}
+	sink := common.NewZeroCopySink(nil)
+	tx.Serialization(sink)
+	letter := &db.DeadLetter{
+		Direction: db.DEAD_LETTER_POLY,
+		ID:        key,
+		Key:       []byte(key),
+		Reason:    reason,
+		Time:      int64(tx.decidedAt),
+		Payload:   sink.Bytes(),
+	}
+	if err := this.db.MoveToDeadLetter(db.BKTBridgeTransactions, letter); err != nil {
+		return err
+	}
+	metrics.Add(METRIC_DEAD_LETTERS, 1)
+	if item := this.items[key]; item.index >= 0 {
+		heap.Remove(&this.ready, item.index)
+	}
+	delete(this.items, key)
+	return nil
+}
+
+// decided returns a copy of transfer key with a fee decision recorded
+func (this *BridgeQueue) decided(key string, hasPay uint8, fee string, reason string) (*BridgeTransaction, bool) {
+	item, ok := this.items[key]
+	if !ok {
+		return nil, false
+	}
+	tx := *item.tx
+	tx.hasPay = hasPay
+	if fee != "" {
+		tx.fee = fee
+	}
+	tx.reason = reason
+	tx.decidedAt = uint64(time.Now().Unix())
+	return &tx, true
+}
+
+// PopReady takes every ready transfer out of the queue, highest score first.
+// Each must be given back to Done or Retry.
+func (this *BridgeQueue) PopReady() []*BridgeTransactionAndHash {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	res := make([]*BridgeTransactionAndHash, 0, this.ready.Len())
+	for this.ready.Len() > 0 {
+		item := heap.Pop(&this.ready).(*bridgeQueueItem)
+		res = append(res, &BridgeTransactionAndHash{BridgeTransaction: item.tx, Hash: item.key})
+	}
+	return res
+}
+
+// Done drops a sent transfer
+func (this *BridgeQueue) Done(key string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return this.remove(key)
+}
+
+// Retry puts a transfer taken by PopReady back, it keeps its enqueue time
+func (this *BridgeQueue) Retry(key string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if item, ok := this.items[key]; ok && item.index < 0 {
+		heap.Push(&this.ready, item)
+	}
+}
+
+func (this *BridgeQueue) remove(key string) error {
+	if item, ok := this.items[key]; ok {
+		if item.index >= 0 {
+			heap.Remove(&this.ready, item.index)
+		}
+		delete(this.items, key)
+	}
+	return this.db.DeleteBridgeTransactions(key)
+}
+
+func (this *BridgeQueue) Len() int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return len(this.items)
+}
@@ -0,0 +1,301 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/polynetwork/polygon-relayer/config"
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
+)
+
+const (
+	CONTRACT_ALLOWED = iota
+	CONTRACT_DENIED
+	CONTRACT_RATE_LIMITED
+)
+
+const (
+	CONTRACT_RULES_POLL     = 10 * time.Second
+	CONTRACT_RULES_MAX_BODY = 1 << 20
+
+	METRIC_CONTRACT_DENIED       = "contracts.denied"
+	METRIC_CONTRACT_RATE_LIMITED = "contracts.rate_limited"
+	METRIC_CONTRACT_RELOADS      = "contracts.reloads"
+
+	ALERT_CONTRACT_RULES_RELOAD = "contract_rules_reload"
+)
+
+// ContractMatcher decides which transfers are relayed, poly to bor transfers
+// by the bor contract they go to and the chain they come from, bor to poly
+// ones by the bor contract they come from and the chain they go to. Rules are
+// reloaded when ContractsFile changes or replaced with the admin api, rate
+// limit windows are kept across reloads.
+type ContractMatcher struct {
+	path string
+
+	mu      sync.Mutex
+	rules   *config.ContractRules
+	modTime time.Time
+	sent    map[string][]time.Time // relay times in the window of a rate limit, by rateKey
+}
+
+func NewContractMatcher(servCfg *config.ServiceConfig) (*ContractMatcher, error) {
+	this := &ContractMatcher{
+		path: servCfg.ContractsFile,
+		sent: make(map[string][]time.Time),
+	}
+	if this.path == "" {
+		this.rules = config.ContractRulesOf(servCfg.TargetContracts)
+		return this, nil
+	}
+	if err := this.reload(); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+// Rules returns the rules in use, they must not be changed
+func (this *ContractMatcher) Rules() *config.ContractRules {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	return this.rules
+}
+
+// Replace replaces the rules, and the rules file if there is one
+func (this *ContractMatcher) Replace(rules *config.ContractRules) error {
+	rules.SetDefaults()
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.path != "" {
+		if err := config.SaveContractRules(this.path, rules); err != nil {
+			return fmt.Errorf("save %s: %s", this.path, err)
+		}
+		if info, err := os.Stat(this.path); err == nil {
+			this.modTime = info.ModTime()
+		}
+	}
+	this.setRules(rules)
+	log.Infof("ContractMatcher.Replace - %d allow and %d deny rules", len(rules.Allow), len(rules.Deny))
+	return nil
+}
+
+// Watch reloads the rules file whenever it changes, it never returns
+func (this *ContractMatcher) Watch() {
+	if this.path == "" {
+		return
+	}
+	ticker := time.NewTicker(CONTRACT_RULES_POLL)
+	for range ticker.C {
+		info, err := os.Stat(this.path)
+		if err != nil {
+			metrics.Alert(ALERT_CONTRACT_RULES_RELOAD, "stat %s: %s, keep the current rules", this.path, err)
+			continue
+		}
+		this.mu.Lock()
+		changed := !info.ModTime().Equal(this.modTime)
+		this.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := this.reload(); err != nil {
+			metrics.Alert(ALERT_CONTRACT_RULES_RELOAD, "%s, keep the current rules", err)
+			continue
+		}
+		metrics.Resolve(ALERT_CONTRACT_RULES_RELOAD)
+	}
+}
+
+func (this *ContractMatcher) reload() error {
+	info, err := os.Stat(this.path)
+	if err != nil {
+		return err
+	}
+	rules, err := config.LoadContractRules(this.path)
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.modTime = info.ModTime()
+	this.setRules(rules)
+	metrics.Add(METRIC_CONTRACT_RELOADS, 1)
+	log.Infof("ContractMatcher.reload - %s: %d allow and %d deny rules", this.path, len(rules.Allow), len(rules.Deny))
+	return nil
+}
+
+func (this *ContractMatcher) setRules(rules *config.ContractRules) {
+	this.rules = rules
+	// drop the windows of limits that are gone
+	keep := make(map[string]bool)
+	for _, rule := range rules.Allow {
+		if rule.RateLimit > 0 {
+			keep[rateKey(config.TARGET_INBOUND, rule)] = true
+			keep[rateKey(config.TARGET_OUTBOUND, rule)] = true
+		}
+	}
+	for k := range this.sent {
+		if !keep[k] {
+			delete(this.sent, k)
+		}
+	}
+}
+
+// ContractBatch matches the transfers of one block. The transfers it allows
+// only count against the rate limits once the block is stored, with Commit,
+// so a block handled again is not counted twice.
+type ContractBatch struct {
+	matcher     *ContractMatcher
+	allowed     map[string]int // transfers allowed by a rate limited rule, by rateKey
+	denied      int64
+	rateLimited int64
+}
+
+// Batch returns a batch for the transfers of one block
+func (this *ContractMatcher) Batch() *ContractBatch {
+	return &ContractBatch{
+		matcher: this,
+		allowed: make(map[string]int),
+	}
+}
+
+// Match returns whether a transfer in direction config.TARGET_INBOUND or
+// config.TARGET_OUTBOUND of bor contract from or to chainId is relayed, and
+// why not. The transfers the batch allowed before count against the limits.
+func (this *ContractBatch) Match(direction string, contract ethcommon.Address, chainId uint64) (int, string) {
+	this.matcher.mu.Lock()
+	defer this.matcher.mu.Unlock()
+
+	rules := this.matcher.rules
+	for i, rule := range rules.Deny {
+		if rule.Matches(direction, contract, chainId) {
+			this.denied++
+			return CONTRACT_DENIED, fmt.Sprintf("%s %s chain %d denied by Deny[%d]", direction, contract.String(), chainId, i)
+		}
+	}
+	if len(rules.Allow) == 0 {
+		return CONTRACT_ALLOWED, ""
+	}
+	for i, rule := range rules.Allow {
+		if !rule.Matches(direction, contract, chainId) {
+			continue
+		}
+		if rule.RateLimit == 0 {
+			return CONTRACT_ALLOWED, ""
+		}
+		key := rateKey(direction, rule)
+		window := time.Duration(rule.RateWindow) * time.Second
+		if this.matcher.inWindow(key, window, time.Now())+this.allowed[key] >= rule.RateLimit {
+			this.rateLimited++
+			return CONTRACT_RATE_LIMITED, fmt.Sprintf("%s %s chain %d over the rate limit of Allow[%d], %d per %ds",
+				direction, contract.String(), chainId, i, rule.RateLimit, rule.RateWindow)
+		}
+		this.allowed[key]++
+		return CONTRACT_ALLOWED, ""
+	}
+	this.denied++
+	return CONTRACT_DENIED, fmt.Sprintf("%s %s chain %d matches no allow rule", direction, contract.String(), chainId)
+}
+
+// Commit counts the transfers the batch allowed against the rate limits, once
+// their block is stored
+func (this *ContractBatch) Commit() {
+	this.matcher.mu.Lock()
+	defer this.matcher.mu.Unlock()
+
+	now := time.Now()
+	for key, n := range this.allowed {
+		for i := 0; i < n; i++ {
+			this.matcher.sent[key] = append(this.matcher.sent[key], now)
+		}
+	}
+	metrics.Add(METRIC_CONTRACT_DENIED, this.denied)
+	metrics.Add(METRIC_CONTRACT_RATE_LIMITED, this.rateLimited)
+	this.allowed = make(map[string]int)
+	this.denied, this.rateLimited = 0, 0
+}
+
+// inWindow drops the relay times of rate limit key older than window and
+// returns how many are left
+func (this *ContractMatcher) inWindow(key string, window time.Duration, now time.Time) int {
+	sent := this.sent[key]
+	for len(sent) > 0 && now.Sub(sent[0]) >= window {
+		sent = sent[1:]
+	}
+	if len(sent) == 0 {
+		delete(this.sent, key)
+	} else {
+		this.sent[key] = sent
+	}
+	return len(sent)
+}
+
+func rateKey(direction string, rule *config.ContractRule) string {
+	return fmt.Sprintf("%s:%s:%d:%d", direction, strings.ToLower(rule.Contract), rule.RateLimit, rule.RateWindow)
+}
+
+func (this *ContractMatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, CONTRACT_RULES_MAX_BODY))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rules := &config.ContractRules{}
+		if err = json.Unmarshal(body, rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = this.Replace(rules); err != nil {
+			status := http.StatusInternalServerError
+			if _, ok := err.(*config.ValidationError); ok {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	raw, err := json.MarshalIndent(this.Rules(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(raw)
+}
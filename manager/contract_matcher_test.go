@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/polynetwork/polygon-relayer/config"
+)
+
+func TestContractBatchRecordsOnCommit(t *testing.T) {
+	matcher, err := NewContractMatcher(&config.ServiceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := ethcommon.HexToAddress("0x250e76987d838a75310c34bf422ea9f1ac4cc906")
+	err = matcher.Replace(&config.ContractRules{Allow: []*config.ContractRule{{
+		Contract:  contract.Hex(),
+		Inbound:   []config.ChainId{"*"},
+		RateLimit: 2,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := []int{CONTRACT_ALLOWED, CONTRACT_ALLOWED, CONTRACT_RATE_LIMITED}
+
+	// a block that fails to be stored is matched again the same way
+	for i := 0; i < 2; i++ {
+		batch := matcher.Batch()
+		for j, want := range block {
+			if got, reason := batch.Match(config.TARGET_INBOUND, contract, 2); got != want {
+				t.Fatalf("try %d, transfer %d: got %d (%s), want %d", i, j, got, reason, want)
+			}
+		}
+	}
+
+	batch := matcher.Batch()
+	for range block {
+		batch.Match(config.TARGET_INBOUND, contract, 2)
+	}
+	batch.Commit()
+	if got, _ := matcher.Batch().Match(config.TARGET_INBOUND, contract, 2); got != CONTRACT_RATE_LIMITED {
+		t.Fatalf("committed transfers not counted, got %d", got)
+	}
+	if got, _ := matcher.Batch().Match(config.TARGET_OUTBOUND, contract, 2); got != CONTRACT_DENIED {
+		t.Fatalf("outbound transfer got %d, want denied", got)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/polynetwork/poly/common"
+	"github.com/polynetwork/polygon-relayer/db"
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
+)
+
+const METRIC_DEAD_LETTERS = "dead_letters"
+
+// deadLetterCrossTransfer moves a bor to poly transfer poly refused out of
+// db.BKTRetry, raw is its retry key
+func deadLetterCrossTransfer(boltDB *db.BoltDB, crosstx *CrossTransfer, raw []byte, reason string) error {
+	metrics.Add(METRIC_DEAD_LETTERS, 1)
+	return boltDB.MoveToDeadLetter(db.BKTRetry, crossTransferLetter(crosstx, raw, reason))
+}
+
+// crossTransferLetter is the dead letter, or held entry, of a bor to poly
+// transfer, raw is its retry key
+func crossTransferLetter(crosstx *CrossTransfer, raw []byte, reason string) *db.DeadLetter {
+	return &db.DeadLetter{
+		Direction: db.DEAD_LETTER_BOR,
+		ID:        crossTransferId(crosstx),
+		Key:       raw,
+		Reason:    reason,
+		Time:      time.Now().Unix(),
+		Payload:   raw,
+	}
+}
+
+// crossTransferId names a bor to poly transfer by its tx hash and ECCM tx
+// index, a tx may hold several transfers
+func crossTransferId(crosstx *CrossTransfer) string {
+	return fmt.Sprintf("%s-%s", ethcommon.BytesToHash(crosstx.txId).String(), crosstx.txIndex)
+}
+
+// bridgeTransactionLetter is the dead letter, or held entry, of a poly to bor
+// transfer that is not queued, stored with its poly block by
+// BridgeQueue.PushBlock
+func bridgeTransactionLetter(tx *BridgeTransaction, reason string) *db.DeadLetter {
+	key := bridgeTransactionKey(tx)
+	sink := common.NewZeroCopySink(nil)
+	tx.Serialization(sink)
+	return &db.DeadLetter{
+		Direction: db.DEAD_LETTER_POLY,
+		ID:        key,
+		Key:       []byte(key),
+		Reason:    reason,
+		Time:      time.Now().Unix(),
+		Payload:   sink.Bytes(),
+	}
+}
+
+// ReplayDeadLetter puts dead letter direction:id back where it came from. A
+// poly to bor transfer gets its fee checked again, a bor to poly one is
+// retried.
+func ReplayDeadLetter(boltDB *db.BoltDB, direction string, id string) error {
+	letter, err := boltDB.GetDeadLetter(direction, id)
+	if err != nil {
+		return err
+	}
+	if letter == nil {
+		return fmt.Errorf("no dead letter %s:%s", direction, id)
+	}
+	if err = restoreTransfer(letter, boltDB.RestoreDeadLetter); err != nil {
+		return err
+	}
+	log.Infof("ReplayDeadLetter - %s:%s replayed, dead-lettered at %s for: %s",
+		letter.Direction, letter.ID, time.Unix(letter.Time, 0).UTC().Format(time.RFC3339), letter.Reason)
+	return nil
+}
+
+// restoreTransfer puts the transfer of letter back with restore, a poly to
+// bor transfer to get its fee checked again, a bor to poly one to be retried
+func restoreTransfer(letter *db.DeadLetter, restore func(letter *db.DeadLetter, bucket []byte, k []byte, v []byte) error) error {
+	switch letter.Direction {
+	case db.DEAD_LETTER_POLY:
+		tx := new(BridgeTransaction)
+		if err := tx.Deserialization(common.NewZeroCopySource(letter.Payload)); err != nil {
+			return fmt.Errorf("deserialize bridge transaction: %s", err)
+		}
+		tx.hasPay, tx.reason, tx.decidedAt = FEE_NOCHECK, "", 0
+		sink := common.NewZeroCopySink(nil)
+		tx.Serialization(sink)
+		return restore(letter, db.BKTBridgeTransactions, letter.Key, sink.Bytes())
+	case db.DEAD_LETTER_BOR:
+		return restore(letter, db.BKTRetry, letter.Key, []byte{0x00})
+	default:
+		return fmt.Errorf("unknown direction %s", letter.Direction)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/polynetwork/poly/common"
+	"github.com/polynetwork/polygon-relayer/db"
+)
+
+// newTestDB returns a BoltDB in a temporary directory removed with the test
+func newTestDB(t *testing.T) *db.BoltDB {
+	dir, err := ioutil.TempDir("", "manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	boltDB, err := db.NewBoltDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { boltDB.Close() })
+	return boltDB
+}
+
+func TestDeadLetterCrossTransfersOfOneTx(t *testing.T) {
+	boltDB := newTestDB(t)
+	txId := ethcommon.HexToHash("0x5e3f").Bytes()
+	for _, index := range []string{"0a", "0b"} {
+		crosstx := &CrossTransfer{txIndex: index, txId: txId, value: []byte{1}, toChain: 2, height: 100}
+		sink := common.NewZeroCopySink(nil)
+		crosstx.Serialization(sink)
+		if err := boltDB.PutRetry(sink.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := deadLetterCrossTransfer(boltDB, crosstx, sink.Bytes(), "refused"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	letters, err := boltDB.GetAllDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("got %d dead letters, want one per transfer of the tx", len(letters))
+	}
+	if letters[0].ID == letters[1].ID {
+		t.Fatalf("transfers of one tx share id %s", letters[0].ID)
+	}
+}
+
+func TestCommitPolyBlockLetters(t *testing.T) {
+	boltDB := newTestDB(t)
+	letter := &db.DeadLetter{Direction: db.DEAD_LETTER_POLY, ID: "2aa", Key: []byte("2aa"), Reason: "rate limited"}
+	held := &db.DeadLetter{Direction: db.DEAD_LETTER_POLY, ID: "2bb", Key: []byte("2bb"), Reason: "over cap"}
+	if err := boltDB.CommitPolyBlock(100, map[string][]byte{"2cc": {1}}, []*db.DeadLetter{letter}, []*db.DeadLetter{held}); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := boltDB.GetPolyHeight(); h != 100 {
+		t.Fatalf("poly height %d, want 100", h)
+	}
+	txs, err := boltDB.LoadBridgeTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || txs["2cc"] == nil {
+		t.Fatalf("bridge transactions %v", txs)
+	}
+	if got, err := boltDB.GetDeadLetter(db.DEAD_LETTER_POLY, "2aa"); err != nil || got == nil || got.Reason != letter.Reason {
+		t.Fatalf("dead letter %+v, error %v", got, err)
+	}
+	if got, err := boltDB.GetHeld(db.DEAD_LETTER_POLY, "2bb"); err != nil || got == nil || got.Reason != held.Reason {
+		t.Fatalf("held transfer %+v, error %v", got, err)
+	}
+}
+
+func TestCommitBorBlockLetters(t *testing.T) {
+	boltDB := newTestDB(t)
+	txId := ethcommon.HexToHash("0x5e3f").Bytes()
+	var raws [][]byte
+	for _, index := range []string{"0a", "0b"} {
+		crosstx := &CrossTransfer{txIndex: index, txId: txId, value: []byte{1}, toChain: 2, height: 100}
+		sink := common.NewZeroCopySink(nil)
+		crosstx.Serialization(sink)
+		raws = append(raws, sink.Bytes())
+	}
+	letter := crossTransferLetter(&CrossTransfer{txIndex: "0b", txId: txId}, raws[1], "rate limited")
+	if err := boltDB.CommitBorBlock(raws[:1], []*db.DeadLetter{letter}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	retries, err := boltDB.GetAllRetry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) != 1 || string(retries[0]) != string(raws[0]) {
+		t.Fatalf("got %d retries, want the transfer not dead-lettered", len(retries))
+	}
+	if got, err := boltDB.GetDeadLetter(db.DEAD_LETTER_BOR, letter.ID); err != nil || got == nil || got.Reason != letter.Reason {
+		t.Fatalf("dead letter %+v, error %v", got, err)
+	}
+}
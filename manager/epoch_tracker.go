@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/polynetwork/eth-contracts/go_abi/eccd_abi"
+	polytypes "github.com/polynetwork/poly/core/types"
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
+	mytypes "github.com/polynetwork/polygon-relayer/types"
+)
+
+const (
+	EPOCH_RESEND_INTERVAL = time.Minute      // changeBookKeeper is sent again if the ECCM has not moved by then
+	EPOCH_STUCK_AFTER     = 10 * time.Minute // an epoch change not synced by then raises ALERT_POLY_EPOCH_STUCK
+
+	METRIC_POLY_EPOCH_HEIGHT = "poly.epoch_height" // epoch start height of the bor ECCM
+	METRIC_POLY_EPOCH_SYNCS  = "poly.epoch_syncs"  // changeBookKeeper sent
+
+	ALERT_POLY_EPOCH_STUCK = "poly_epoch_stuck"
+)
+
+// EpochTracker syncs poly epoch changes to the bor ECCM. The poly scan stops
+// at an epoch change until GetCurEpochStartHeight reflects it, since the
+// proofs of every later transfer are checked against the new bookkeepers.
+type EpochTracker struct {
+	eccd         *eccd_abi.EthCrossChainData
+	selectSender func() *EthSender
+
+	height uint32    // epoch change being synced, 0 if none
+	since  time.Time // when it was seen
+	sentAt time.Time // when changeBookKeeper was last sent
+}
+
+func NewEpochTracker(eccd *eccd_abi.EthCrossChainData, selectSender func() *EthSender) *EpochTracker {
+	return &EpochTracker{
+		eccd:         eccd,
+		selectSender: selectSender,
+	}
+}
+
+// Current returns the epoch start height of the bor ECCM
+func (this *EpochTracker) Current() (uint32, error) {
+	height, err := this.eccd.GetCurEpochStartHeight(nil)
+	if err != nil {
+		return 0, err
+	}
+	metrics.Set(METRIC_POLY_EPOCH_HEIGHT, int64(height))
+	return uint32(height), nil
+}
+
+// Sync returns nil once the epoch change of hdr is synced to the ECCM. It
+// sends changeBookKeeper, again every EPOCH_RESEND_INTERVAL, and returns an
+// error wrapping types.ErrEpochNotSynced until then.
+func (this *EpochTracker) Sync(hdr *polytypes.Header, pubkList []byte) error {
+	cur, err := this.Current()
+	if err != nil {
+		return fmt.Errorf("GetCurEpochStartHeight: %w", err)
+	}
+	if cur >= hdr.Height {
+		this.synced(hdr.Height)
+		return nil
+	}
+
+	now := time.Now()
+	if this.height != hdr.Height {
+		log.Infof("EpochTracker.Sync - poly epoch change at %d, ECCM epoch at %d", hdr.Height, cur)
+		this.height, this.since, this.sentAt = hdr.Height, now, time.Time{}
+	}
+	if now.Sub(this.since) > EPOCH_STUCK_AFTER {
+		metrics.Alert(ALERT_POLY_EPOCH_STUCK, "poly epoch change at %d not synced to the ECCM for %s, ECCM epoch at %d",
+			hdr.Height, now.Sub(this.since).Round(time.Second), cur)
+	}
+	if !this.sentAt.IsZero() && now.Sub(this.sentAt) < EPOCH_RESEND_INTERVAL {
+		return fmt.Errorf("epoch change at %d sent at %s, ECCM epoch at %d: %w",
+			hdr.Height, this.sentAt.Format(time.RFC3339), cur, mytypes.ErrEpochNotSynced)
+	}
+
+	sender := this.selectSender()
+	if sender == nil {
+		return fmt.Errorf("no sender for the epoch change at %d: %w", hdr.Height, mytypes.ErrEpochNotSynced)
+	}
+	this.sentAt = now
+	metrics.Add(METRIC_POLY_EPOCH_SYNCS, 1)
+	if !sender.commitHeader(hdr, pubkList) {
+		return fmt.Errorf("changeBookKeeper of the epoch change at %d failed: %w", hdr.Height, mytypes.ErrEpochNotSynced)
+	}
+	if cur, err = this.Current(); err == nil && cur >= hdr.Height {
+		this.synced(hdr.Height)
+		return nil
+	}
+	return fmt.Errorf("epoch change at %d sent, ECCM epoch at %d: %w", hdr.Height, cur, mytypes.ErrEpochNotSynced)
+}
+
+func (this *EpochTracker) synced(height uint32) {
+	if this.height == 0 {
+		return
+	}
+	log.Infof("EpochTracker.synced - poly epoch change at %d synced to the ECCM after %s", height, time.Since(this.since).Round(time.Second))
+	metrics.Resolve(ALERT_POLY_EPOCH_STUCK)
+	this.height = 0
+}
@@ -36,13 +36,13 @@ import (
 	common2 "github.com/polynetwork/poly/native/service/cross_chain_manager/common"
 	"github.com/polynetwork/polygon-relayer/config"
 	"github.com/polynetwork/polygon-relayer/cosmos-relayer/service"
-	"github.com/polynetwork/polygon-relayer/cosmos-sdk/codec"
 	"github.com/polynetwork/polygon-relayer/db"
+	"github.com/polynetwork/polygon-relayer/heimdall"
+	"github.com/polynetwork/polygon-relayer/metrics"
 	"github.com/polynetwork/polygon-relayer/types"
 	mytypes "github.com/polynetwork/polygon-relayer/types"
 
 	"github.com/christianxiao/tendermint/crypto/merkle"
-	rpcclient "github.com/christianxiao/tendermint/rpc/client"
 
 	"context"
 
@@ -120,13 +120,17 @@ type EthereumManager struct {
 	forceHeight    uint64
 	lockerContract *bind.BoundContract
 	polySdk        *sdkp.PolySdk
-	polySigner     *sdk.Account
+	polySigner     sdkp.Signer
 	exitChan       chan int
 	header4sync    [][]byte
 	crosstx4sync   []*CrossTransfer
 	db             *db.BoltDB
 
 	TendermintClient *TendermintClient
+	borVerifier      *BorVerifier
+	spanGate         *SpanGate
+	contracts        *ContractMatcher
+	valueCaps        *ValueCaps
 
 	LastSpanId   uint64
 	LastSpanId2  uint64
@@ -134,9 +138,53 @@ type EthereumManager struct {
 
 func NewEthereumManager(servconfig *config.ServiceConfig, startheight uint64, startforceheight uint64, ontsdk *sdkp.PolySdk, client *ethclient.Client,
 	boltDB *db.BoltDB,
-	tendermintRPCURL string,
-	cdc *codec.Codec,
-	tclientHttp *rpcclient.HTTP) (*EthereumManager, error) {
+	hclient *heimdall.Client,
+	contracts *ContractMatcher,
+	valueCaps *ValueCaps) (*EthereumManager, error) {
+	signer, err := newPolySigner(servconfig, ontsdk)
+	if err != nil {
+		return nil, err
+	}
+	polyAddress := signer.GetAddress()
+	log.Infof("NewETHManager - poly address: %s", polyAddress.ToBase58())
+
+	tclient, err := NewTendermintClient(boltDB, hclient)
+	if err != nil {
+		log.Errorf("ethereummanager.New - NewTendermintClient error: %s", err.Error())
+		return nil, err
+	}
+
+	mgr := &EthereumManager{
+		config:           servconfig,
+		exitChan:         make(chan int),
+		currentHeight:    startheight,
+		forceHeight:      startforceheight,
+		restClient:       tools.NewRestClient(),
+		client:           client,
+		polySdk:          ontsdk,
+		polySigner:       signer,
+		header4sync:      make([][]byte, 0),
+		crosstx4sync:     make([]*CrossTransfer, 0),
+		db:               boltDB,
+		TendermintClient: tclient,
+		borVerifier:      NewBorVerifier(tclient, servconfig.ETHConfig.ProducerCheck),
+		spanGate:         NewSpanGate(tclient),
+		contracts:        contracts,
+		valueCaps:        valueCaps,
+	}
+	err = mgr.init()
+	if err != nil {
+		return nil, err
+	} else {
+		return mgr, nil
+	}
+}
+
+func newPolySigner(servconfig *config.ServiceConfig, ontsdk *sdkp.PolySdk) (sdkp.Signer, error) {
+	if servconfig.PolyConfig.SignerURL != "" {
+		return sdkp.NewRemoteSigner(servconfig.PolyConfig.SignerURL, servconfig.PolyConfig.SignerPublicKey)
+	}
+
 	var wallet *sdk.Wallet
 	var err error
 	if !common.FileExisted(servconfig.PolyConfig.WalletFile) {
@@ -164,33 +212,7 @@ func NewEthereumManager(servconfig *config.ServiceConfig, startheight uint64, st
 			return nil, err
 		}
 	}
-	log.Infof("NewETHManager - poly address: %s", signer.Address.ToBase58())
-
-	tclient, err := NewTendermintClient(tendermintRPCURL, boltDB, cdc, tclientHttp)
-	if err != nil {
-		log.Errorf("ethereummanager.New - NewTendermintClient error, address: %s, error: %s", tendermintRPCURL, err.Error())
-	}
-
-	mgr := &EthereumManager{
-		config:           servconfig,
-		exitChan:         make(chan int),
-		currentHeight:    startheight,
-		forceHeight:      startforceheight,
-		restClient:       tools.NewRestClient(),
-		client:           client,
-		polySdk:          ontsdk,
-		polySigner:       signer,
-		header4sync:      make([][]byte, 0),
-		crosstx4sync:     make([]*CrossTransfer, 0),
-		db:               boltDB,
-		TendermintClient: tclient,
-	}
-	err = mgr.init()
-	if err != nil {
-		return nil, err
-	} else {
-		return mgr, nil
-	}
+	return sdkp.NewAccountSigner(signer), nil
 }
 
 func (this *EthereumManager) SyncHeaderToPoly() error {
@@ -222,12 +244,30 @@ func (this *EthereumManager) SyncHeaderToPoly() error {
 
 			log.Infof("SyncHeaderToPoly - eth height is %d, currentheight: %d, diff: %d", height, currentHeight, height-currentHeight)
 
-			for currentHeight < height-config.ETH_USEFUL_BLOCK_NUM {
+			// sync up to the end of the latest span provable on poly
+			target := height - config.ETH_USEFUL_BLOCK_NUM
+			if ready, ok := this.spanGate.ReadyHeight(); ok && ready+1 < target {
+				if currentHeight > ready {
+					log.Infof("SyncHeaderToPoly - waiting for the span of bor height %d on heimdall, ready height: %d", currentHeight, ready)
+					this.requireLatestHeimdall()
+					continue
+				}
+				target = ready + 1
+			}
+
+			for currentHeight < target {
 				err := this.handleBlockHeader(currentHeight)
 
 				if err != nil {
 					if errors.Is(err, mytypes.ErrSpanNotFound) {
 						log.Warnf("SyncHeaderToPoly error - ErrSpanNotFound, the bor and spanId is too new on heimdall height, bor height: %d, error: %w", currentHeight, err)
+					} else if errors.Is(err, mytypes.ErrInvalidBorHeader) {
+						log.Errorf("SyncHeaderToPoly error - header rejected, drop %d pending headers, bor height: %d, error: %s",
+							len(this.header4sync), currentHeight, err)
+						// the pending headers may be on a reorganized branch, fetch them again
+						currentHeight = currentHeight - uint64(len(this.header4sync))
+						this.header4sync = make([][]byte, 0)
+						this.borVerifier.Reset()
 					} else {
 						log.Errorf("SyncHeaderToPoly error - handleBlockHeader error, height: %d, error: %w", currentHeight, err)
 					}
@@ -235,7 +275,7 @@ func (this *EthereumManager) SyncHeaderToPoly() error {
 				}
 
 				if len(this.header4sync) >= this.config.ETHConfig.HeadersPerBatch ||
-					(currentHeight == height-config.ETH_USEFUL_BLOCK_NUM-1 && len(this.header4sync) > 0) {
+					(currentHeight == target-1 && len(this.header4sync) > 0) {
 					if err := this.commitHeader(&currentHeight); err != nil {
 						if strings.Contains(err.Error(), "block validator is not right, next validator hash:") {
 							log.Warnf("SyncHeaderToPoly commit error: %w", err)
@@ -339,6 +379,7 @@ func (this *EthereumManager) SyncEventToPoly() error {
 }
 
 func (this *EthereumManager) MonitorChain() {
+	go this.spanGate.Monitor(this.config.ETHConfig.MonitorInterval)
 	go this.SyncHeaderToPoly()
 	go this.SyncEventToPoly()
 }
@@ -423,8 +464,12 @@ func (this *EthereumManager) makeHeaderWithOptionalProof(height uint64, eth *eth
 			return headerWithOptionalProof, nil
 		}
 
-	spanRes, _, err := this.TendermintClient.GetSpanRes(spanId, hHeight-1)
+	spanRes, err := this.TendermintClient.GetSpanResCached(spanId, hHeight-1)
 	if err != nil {
+		if errors.Is(err, mytypes.ErrSpanNotFound) {
+			// hold the header until a heimdall height knowing the span is confirmed on poly
+			this.requireLatestHeimdall()
+		}
 		return nil, fmt.Errorf("ethereummanager.handleBlockHeader - tendermintClient.GetSpan error, on hHeight :%d, id: %d, error: %w",
 			hHeight-1, spanId, err)
 	}
@@ -461,6 +506,18 @@ func (this *EthereumManager) makeHeaderWithOptionalProof(height uint64, eth *eth
 	return headerWithOptionalProof, nil
 }
 
+// requireLatestHeimdall asks the heimdall listener to get the latest heimdall
+// height confirmed on poly, for a span heimdall knows but poly cannot prove yet
+func (this *EthereumManager) requireLatestHeimdall() {
+	latest, err := this.TendermintClient.GetLatestHeight()
+	if err != nil {
+		log.Errorf("requireLatestHeimdall - GetLatestHeight error: %s", err)
+		return
+	}
+	// the listener relays up to the latest height - 1
+	service.RequireHeimdallHeight(latest - 1)
+}
+
 func (this *EthereumManager) handleBlockHeader(height uint64) error {
 
 	hdreth, err := this.client.HeaderByNumber(context.Background(), big.NewInt(int64(height)))
@@ -468,6 +525,10 @@ func (this *EthereumManager) handleBlockHeader(height uint64) error {
 		return fmt.Errorf("handleBlockHeader - GetNodeHeader on height: %d failed, error: %w", height, err)
 	}
 
+	if err = this.borVerifier.Verify(hdreth); err != nil {
+		return fmt.Errorf("handleBlockHeader - verify header on height: %d failed, error: %w", height, err)
+	}
+
 	hdr, err := this.makeHeaderWithOptionalProof(height, hdreth)
 	if err != nil {
 		return fmt.Errorf("handleBlockHeader - makeHeaderWithOptionalProof error on height :%d failed, error: %w",
@@ -506,35 +567,13 @@ func (this *EthereumManager) fetchLockDepositEvents(height uint64, client *ethcl
 		return false
 	}
 
+	contracts := this.contracts.Batch()
+	valueCaps := this.valueCaps.Batch()
+	retries := make([][]byte, 0)
+	letters := make([]*db.DeadLetter, 0)
+	held := make([]*db.DeadLetter, 0)
 	for events.Next() {
 		evt := events.Event
-		var isTarget bool
-
-		if len(this.config.TargetContracts) > 0 {
-			toContractStr := evt.ProxyOrAssetContract.String()
-			for _, v := range this.config.TargetContracts {
-				toChainIdArr, ok := v[toContractStr]
-				if ok {
-					if len(toChainIdArr["outbound"]) == 0 {
-						isTarget = true
-						break
-					}
-					for _, id := range toChainIdArr["outbound"] {
-						if id == evt.ToChainId {
-							isTarget = true
-							break
-						}
-					}
-					if isTarget {
-						break
-					}
-				}
-			}
-			if !isTarget {
-				continue
-			}
-		}
-
 		param := &common2.MakeTxParam{}
 		_ = param.Deserialization(common.NewZeroCopySource([]byte(evt.Rawdata)))
 
@@ -557,12 +596,33 @@ func (this *EthereumManager) fetchLockDepositEvents(height uint64, client *ethcl
 		}
 		sink := common.NewZeroCopySink(nil)
 		crossTx.Serialization(sink)
-		err = this.db.PutRetry(sink.Bytes())
-		if err != nil {
-			log.Errorf("fetchLockDepositEvents - this.db.PutRetry error: %s", err)
+		switch decision, reason := contracts.Match(config.TARGET_OUTBOUND, evt.ProxyOrAssetContract, evt.ToChainId); decision {
+		case CONTRACT_DENIED:
+			log.Debugf("fetchLockDepositEvents - skip tx %s: %s", evt.Raw.TxHash.Hex(), reason)
+			continue
+		case CONTRACT_RATE_LIMITED:
+			log.Warnf("fetchLockDepositEvents - dead-letter tx %s: %s", evt.Raw.TxHash.Hex(), reason)
+			letters = append(letters, crossTransferLetter(crossTx, sink.Bytes(), reason))
+			continue
 		}
+		if isHeld, reason := valueCaps.Check(evt.ProxyOrAssetContract, evt.ToChainId, param.Args); isHeld {
+			log.Warnf("fetchLockDepositEvents - hold tx %s: %s", evt.Raw.TxHash.Hex(), reason)
+			held = append(held, crossTransferLetter(crossTx, sink.Bytes(), reason))
+			continue
+		}
+		retries = append(retries, sink.Bytes())
 		log.Infof("fetchLockDepositEvent -  height: %d", height)
 	}
+	if err = this.db.CommitBorBlock(retries, letters, held); err != nil {
+		log.Errorf("fetchLockDepositEvents - this.db.CommitBorBlock error: %s", err)
+		return false
+	}
+	contracts.Commit()
+	valueCaps.Commit()
+	metrics.Add(METRIC_DEAD_LETTERS, int64(len(letters)))
+	for _, v := range held {
+		transferHeld(v)
+	}
 	return true
 }
 
@@ -572,9 +632,8 @@ func (this *EthereumManager) commitHeader(currentHeight *uint64) error {
 	snycheightLast := this.findLastestHeight()
 
 	lenh := len(this.header4sync)
-	tx, err := this.polySdk.Native.Hs.SyncBlockHeader(
+	tx, err := this.polySdk.SyncBlockHeader(
 		this.config.ETHConfig.SideChainId,
-		this.polySigner.Address,
 		this.header4sync,
 		this.polySigner,
 	)
@@ -693,14 +752,16 @@ func (this *EthereumManager) handleLockDepositEvents(refHeight uint64) error {
 			if strings.Contains(err.Error(), "chooseUtxos, current utxo is not enough") {
 				log.Infof("handleLockDepositEvents - invokeNativeContract error, refHeight: %d, error: %s", refHeight, err)
 				continue
-			} else {
+			} else if strings.Contains(err.Error(), "tx already done") {
 				if err := this.db.DeleteRetry(v); err != nil {
 					log.Errorf("handleLockDepositEvents - this.db.DeleteRetry error, refHeight: %d, error: %s", refHeight, err)
 				}
-				if strings.Contains(err.Error(), "tx already done") {
-					log.Debugf("handleLockDepositEvents - eth_tx %s already on poly, refHeight: %d", ethcommon.BytesToHash(crosstx.txId).String(), refHeight)
-				} else {
-					log.Errorf("handleLockDepositEvents - invokeNativeContract error, refHeight: %d, for eth_tx %s: %s", refHeight, ethcommon.BytesToHash(crosstx.txId).String(), err)
+				log.Debugf("handleLockDepositEvents - eth_tx %s already on poly, refHeight: %d", ethcommon.BytesToHash(crosstx.txId).String(), refHeight)
+				continue
+			} else {
+				log.Errorf("handleLockDepositEvents - invokeNativeContract error, refHeight: %d, for eth_tx %s: %s", refHeight, ethcommon.BytesToHash(crosstx.txId).String(), err)
+				if err := deadLetterCrossTransfer(this.db, crosstx, v, err.Error()); err != nil {
+					log.Errorf("handleLockDepositEvents - dead letter eth_tx %s error: %s", ethcommon.BytesToHash(crosstx.txId).String(), err)
 				}
 				continue
 			}
@@ -721,12 +782,13 @@ func (this *EthereumManager) handleLockDepositEvents(refHeight uint64) error {
 
 func (this *EthereumManager) commitProof(height uint32, proof []byte, value []byte, txhash []byte) (string, error) {
 	log.Debugf("commit proof, height: %d, proof: %s, value: %s, txhash: %s", height, string(proof), hex.EncodeToString(value), hex.EncodeToString(txhash))
-	tx, err := this.polySdk.Native.Ccm.ImportOuterTransfer(
+	relayerAddress := this.polySigner.GetAddress()
+	tx, err := this.polySdk.ImportOuterTransfer(
 		this.config.ETHConfig.SideChainId,
 		value,
 		height,
 		proof,
-		ethcommon.Hex2Bytes(this.polySigner.Address.ToHexString()),
+		ethcommon.Hex2Bytes(relayerAddress.ToHexString()),
 		[]byte{},
 		this.polySigner)
 	if err != nil {
@@ -0,0 +1,274 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"poly_bridge_sdk"
+
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
+)
+
+const (
+	METRIC_FEE_CHECKS       = "fee.checks"        // transfers asked to the bridge
+	METRIC_FEE_CHECK_ERRORS = "fee.check_errors"  // failed calls to a bridge url group
+	METRIC_FEE_FAILOVERS    = "fee.failovers"     // switches to the next bridge url group
+	METRIC_FEE_BRIDGE_GROUP = "fee.bridge_group"  // index of the bridge url group in use
+	METRIC_FEE_CHECK_MS     = "fee.check_ms"      // duration of the last bridge call
+	METRIC_FEE_CACHE_HITS   = "fee.cache_hits"    // transfers answered from the cache
+	METRIC_FEE_UNKNOWN      = "fee.unknown_state" // transfers the bridge could not tell about
+
+	ALERT_FEE_CHECK_FAILED = "fee_check_failed"
+
+	FEE_CHECK_SLOT = 5
+)
+
+type FeeCheckReq struct {
+	ChainId uint64
+	Hash    string // hex of the source chain tx hash
+}
+
+func (this *FeeCheckReq) key() string {
+	return fmt.Sprintf("%d%s", this.ChainId, this.Hash)
+}
+
+// FeeCheckRsp is the fee state of a transfer, PayState is FEE_HASPAY,
+// FEE_NOTPAY or FEE_NOCHECK if it is not known
+type FeeCheckRsp struct {
+	ChainId  uint64
+	Hash     string
+	PayState uint8
+	Amount   string // fee paid, if any
+}
+
+// FeeChecker tells whether the fee of transfers is paid. It answers the
+// requests it got an answer for. An error means the fee service could not be
+// reached, the answers returned with it, if any, are still valid.
+type FeeChecker interface {
+	CheckFee(reqs []*FeeCheckReq) ([]*FeeCheckRsp, error)
+}
+
+// NewFeeChecker returns the fee checker of the bridge urls, its results
+// cached for ttl
+func NewFeeChecker(urls [][]string, ttl time.Duration) FeeChecker {
+	return NewCachedFeeChecker(NewBridgeFeeChecker(urls), ttl)
+}
+
+// BridgeFeeChecker asks the poly bridge. It keeps using one group of
+// BridgeUrl, and fails over to the next group when a call fails.
+type BridgeFeeChecker struct {
+	mu      sync.Mutex
+	urls    [][]string
+	groups  []FeeChecker // one per group of urls
+	current int
+}
+
+func NewBridgeFeeChecker(urls [][]string) *BridgeFeeChecker {
+	groups := make([]FeeChecker, len(urls))
+	for i, v := range urls {
+		groups[i] = &sdkFeeChecker{sdk: poly_bridge_sdk.NewBridgeFeeCheck([][]string{v}, FEE_CHECK_SLOT)}
+	}
+	return &BridgeFeeChecker{
+		urls:   urls,
+		groups: groups,
+	}
+}
+
+func (this *BridgeFeeChecker) CheckFee(reqs []*FeeCheckReq) ([]*FeeCheckRsp, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if len(this.groups) == 0 {
+		return nil, fmt.Errorf("no BridgeUrl configured")
+	}
+	metrics.Add(METRIC_FEE_CHECKS, int64(len(reqs)))
+
+	var lastErr error
+	for i := 0; i < len(this.groups); i++ {
+		group := (this.current + i) % len(this.groups)
+		if i > 0 {
+			log.Warnf("BridgeFeeChecker.CheckFee - fail over to bridge url group %d %v", group, this.urls[group])
+			metrics.Add(METRIC_FEE_FAILOVERS, 1)
+		}
+		start := time.Now()
+		rsps, err := this.groups[group].CheckFee(reqs)
+		metrics.Set(METRIC_FEE_CHECK_MS, time.Since(start).Milliseconds())
+		if err != nil {
+			log.Errorf("BridgeFeeChecker.CheckFee - bridge url group %d %v error: %s", group, this.urls[group], err)
+			metrics.Add(METRIC_FEE_CHECK_ERRORS, 1)
+			lastErr = err
+			continue
+		}
+		this.current = group
+		metrics.Set(METRIC_FEE_BRIDGE_GROUP, int64(group))
+		return rsps, nil
+	}
+	return nil, fmt.Errorf("every bridge url group failed, last error: %w", lastErr)
+}
+
+// sdkFeeChecker asks one group of bridge urls through the sdk
+type sdkFeeChecker struct {
+	sdk *poly_bridge_sdk.BridgeFeeCheck
+}
+
+func (this *sdkFeeChecker) CheckFee(reqs []*FeeCheckReq) ([]*FeeCheckRsp, error) {
+	sdkReqs := make([]*poly_bridge_sdk.CheckFeeReq, len(reqs))
+	for i, v := range reqs {
+		sdkReqs[i] = &poly_bridge_sdk.CheckFeeReq{ChainId: v.ChainId, Hash: v.Hash}
+	}
+	sdkRsps, err := this.sdk.CheckFee(sdkReqs)
+	if err != nil {
+		return nil, err
+	}
+	return bridgeFeeRsps(sdkRsps), nil
+}
+
+func bridgeFeeRsps(sdkRsps []*poly_bridge_sdk.CheckFeeRsp) []*FeeCheckRsp {
+	rsps := make([]*FeeCheckRsp, 0, len(sdkRsps))
+	for _, v := range sdkRsps {
+		rsp := &FeeCheckRsp{ChainId: v.ChainId, Hash: v.Hash, PayState: FEE_NOCHECK}
+		switch {
+		case v.Error != "":
+			log.Errorf("BridgeFeeChecker.CheckFee - tx(%d,%s) error: %s", v.ChainId, v.Hash, v.Error)
+		case v.PayState == poly_bridge_sdk.STATE_HASPAY:
+			rsp.PayState, rsp.Amount = FEE_HASPAY, v.Amount
+		case v.PayState == poly_bridge_sdk.STATE_NOTPAY:
+			rsp.PayState = FEE_NOTPAY
+		}
+		if rsp.PayState == FEE_NOCHECK {
+			metrics.Add(METRIC_FEE_UNKNOWN, 1)
+		}
+		rsps = append(rsps, rsp)
+	}
+	return rsps
+}
+
+// MemFeeChecker answers from memory, for tests and local runs without a
+// bridge. Transfers not set have the default state.
+type MemFeeChecker struct {
+	mu       sync.Mutex
+	fees     map[string]*FeeCheckRsp
+	Default  uint8
+	Err      error // returned by CheckFee when set
+	Requests int   // transfers asked so far
+}
+
+func NewMemFeeChecker(defaultState uint8) *MemFeeChecker {
+	return &MemFeeChecker{
+		fees:    make(map[string]*FeeCheckRsp),
+		Default: defaultState,
+	}
+}
+
+// Set sets the fee state of a transfer
+func (this *MemFeeChecker) Set(chainId uint64, hash string, payState uint8, amount string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	req := &FeeCheckReq{ChainId: chainId, Hash: hash}
+	this.fees[req.key()] = &FeeCheckRsp{ChainId: chainId, Hash: hash, PayState: payState, Amount: amount}
+}
+
+func (this *MemFeeChecker) CheckFee(reqs []*FeeCheckReq) ([]*FeeCheckRsp, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.Requests += len(reqs)
+	if this.Err != nil {
+		return nil, this.Err
+	}
+	rsps := make([]*FeeCheckRsp, len(reqs))
+	for i, v := range reqs {
+		if rsp, ok := this.fees[v.key()]; ok {
+			c := *rsp
+			rsps[i] = &c
+		} else {
+			rsps[i] = &FeeCheckRsp{ChainId: v.ChainId, Hash: v.Hash, PayState: this.Default}
+		}
+	}
+	return rsps, nil
+}
+
+type cachedFee struct {
+	rsp     *FeeCheckRsp
+	expires time.Time
+}
+
+// CachedFeeChecker keeps the answers of another FeeChecker for ttl, so the
+// transfers waiting in the queue are not all asked again on every tick. Only
+// FEE_HASPAY and FEE_NOTPAY are kept, a transfer the bridge does not know yet
+// is asked again.
+type CachedFeeChecker struct {
+	mu      sync.Mutex
+	checker FeeChecker
+	ttl     time.Duration
+	cache   map[string]*cachedFee
+}
+
+func NewCachedFeeChecker(checker FeeChecker, ttl time.Duration) *CachedFeeChecker {
+	return &CachedFeeChecker{
+		checker: checker,
+		ttl:     ttl,
+		cache:   make(map[string]*cachedFee),
+	}
+}
+
+func (this *CachedFeeChecker) CheckFee(reqs []*FeeCheckReq) ([]*FeeCheckRsp, error) {
+	this.mu.Lock()
+	now := time.Now()
+	for k, v := range this.cache {
+		if now.After(v.expires) {
+			delete(this.cache, k)
+		}
+	}
+	rsps := make([]*FeeCheckRsp, 0, len(reqs))
+	misses := make([]*FeeCheckReq, 0, len(reqs))
+	for _, v := range reqs {
+		if c, ok := this.cache[v.key()]; ok {
+			rsps = append(rsps, c.rsp)
+		} else {
+			misses = append(misses, v)
+		}
+	}
+	this.mu.Unlock()
+	metrics.Add(METRIC_FEE_CACHE_HITS, int64(len(rsps)))
+
+	if len(misses) == 0 {
+		return rsps, nil
+	}
+	fresh, err := this.checker.CheckFee(misses)
+	if err != nil {
+		return rsps, err
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	expires := time.Now().Add(this.ttl)
+	for _, v := range fresh {
+		if v.PayState != FEE_HASPAY && v.PayState != FEE_NOTPAY {
+			continue
+		}
+		req := &FeeCheckReq{ChainId: v.ChainId, Hash: v.Hash}
+		this.cache[req.key()] = &cachedFee{rsp: v, expires: expires}
+	}
+	return append(rsps, fresh...), nil
+}
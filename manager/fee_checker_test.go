@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachedFeeCheckerTTL(t *testing.T) {
+	mem := NewMemFeeChecker(FEE_NOTPAY)
+	mem.Set(2, "aa", FEE_HASPAY, "10")
+	checker := NewCachedFeeChecker(mem, 50*time.Millisecond)
+	reqs := []*FeeCheckReq{{ChainId: 2, Hash: "aa"}}
+
+	for i, want := range []int{1, 1} {
+		rsps, err := checker.CheckFee(reqs)
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if len(rsps) != 1 || rsps[0].PayState != FEE_HASPAY || rsps[0].Amount != "10" {
+			t.Fatalf("call %d: got %+v", i, rsps)
+		}
+		if mem.Requests != want {
+			t.Fatalf("call %d: %d requests to the checker, want %d", i, mem.Requests, want)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	mem.Set(2, "aa", FEE_NOTPAY, "")
+	rsps, err := checker.CheckFee(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.Requests != 2 {
+		t.Fatalf("expired answer not asked again, %d requests", mem.Requests)
+	}
+	if rsps[0].PayState != FEE_NOTPAY {
+		t.Fatalf("expired answer served: %+v", rsps[0])
+	}
+}
+
+func TestCachedFeeCheckerUnknownNotCached(t *testing.T) {
+	mem := NewMemFeeChecker(FEE_NOCHECK)
+	checker := NewCachedFeeChecker(mem, time.Minute)
+	reqs := []*FeeCheckReq{{ChainId: 2, Hash: "aa"}}
+	if _, err := checker.CheckFee(reqs); err != nil {
+		t.Fatal(err)
+	}
+
+	mem.Set(2, "aa", FEE_HASPAY, "10")
+	rsps, err := checker.CheckFee(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.Requests != 2 {
+		t.Fatalf("unknown answer served from the cache, %d requests", mem.Requests)
+	}
+	if rsps[0].PayState != FEE_HASPAY {
+		t.Fatalf("got %+v", rsps[0])
+	}
+}
+
+func TestCachedFeeCheckerPartialHitWithError(t *testing.T) {
+	mem := NewMemFeeChecker(FEE_NOTPAY)
+	mem.Set(2, "aa", FEE_HASPAY, "10")
+	checker := NewCachedFeeChecker(mem, time.Minute)
+	if _, err := checker.CheckFee([]*FeeCheckReq{{ChainId: 2, Hash: "aa"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	down := errors.New("bridge down")
+	mem.Err = down
+	rsps, err := checker.CheckFee([]*FeeCheckReq{{ChainId: 2, Hash: "aa"}, {ChainId: 2, Hash: "bb"}})
+	if !errors.Is(err, down) {
+		t.Fatalf("got error %v, want %v", err, down)
+	}
+	if len(rsps) != 1 || rsps[0].Hash != "aa" || rsps[0].PayState != FEE_HASPAY {
+		t.Fatalf("cached answer not returned with the error: %+v", rsps)
+	}
+
+	// the failed transfer is not cached, only it is asked again
+	mem.Err = nil
+	requests := mem.Requests
+	rsps, err = checker.CheckFee([]*FeeCheckReq{{ChainId: 2, Hash: "aa"}, {ChainId: 2, Hash: "bb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsps) != 2 {
+		t.Fatalf("got %d answers, want 2", len(rsps))
+	}
+	if mem.Requests-requests != 1 {
+		t.Fatalf("%d transfers asked again, want 1", mem.Requests-requests)
+	}
+}
+
+func TestBridgeFeeCheckerFailover(t *testing.T) {
+	down := errors.New("group down")
+	first := NewMemFeeChecker(FEE_HASPAY)
+	first.Err = down
+	second := NewMemFeeChecker(FEE_NOTPAY)
+	checker := &BridgeFeeChecker{
+		urls:   [][]string{{"http://a"}, {"http://b"}},
+		groups: []FeeChecker{first, second},
+	}
+	reqs := []*FeeCheckReq{{ChainId: 2, Hash: "aa"}}
+
+	rsps, err := checker.CheckFee(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsps) != 1 || rsps[0].PayState != FEE_NOTPAY {
+		t.Fatalf("answer not from the second group: %+v", rsps)
+	}
+	if checker.current != 1 {
+		t.Fatalf("current group %d, want 1", checker.current)
+	}
+
+	// the working group is kept
+	if _, err := checker.CheckFee(reqs); err != nil {
+		t.Fatal(err)
+	}
+	if first.Requests != 1 || second.Requests != 2 {
+		t.Fatalf("requests %d/%d, want 1/2", first.Requests, second.Requests)
+	}
+
+	// fails over back to the first group once it is up
+	second.Err = down
+	first.Err = nil
+	rsps, err = checker.CheckFee(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsps[0].PayState != FEE_HASPAY || checker.current != 0 {
+		t.Fatalf("not failed over to the first group: %+v, current %d", rsps[0], checker.current)
+	}
+
+	first.Err = down
+	if _, err := checker.CheckFee(reqs); !errors.Is(err, down) {
+		t.Fatalf("got error %v when every group is down", err)
+	}
+	if _, err := (&BridgeFeeChecker{}).CheckFee(reqs); err == nil {
+		t.Fatal("no error without groups")
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/polynetwork/polygon-relayer/config"
+)
+
+const (
+	METRIC_FEE_RELAYED  = "fee.relayed"
+	METRIC_FEE_DEFERRED = "fee.deferred"
+	METRIC_FEE_REJECTED = "fee.rejected"
+)
+
+type FeeDecision int
+
+const (
+	FEE_RELAY FeeDecision = iota
+	FEE_DEFER
+	FEE_REJECT
+)
+
+func (this FeeDecision) String() string {
+	switch this {
+	case FEE_RELAY:
+		return "relay"
+	case FEE_DEFER:
+		return "defer"
+	case FEE_REJECT:
+		return "reject"
+	}
+	return fmt.Sprintf("FeeDecision(%d)", int(this))
+}
+
+// 1 MATIC in wei
+var maticWei = new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil))
+
+// FeePolicy decides whether a transfer checked by the bridge is relayed, see
+// config.FeePolicyConfig. Every decision comes with the reason it was taken.
+type FeePolicy struct {
+	config        *config.FeePolicyConfig
+	minFee        map[string]*big.Float // by "fromChainId:toContract", "fromChainId" or "*"
+	freeContracts map[ethcommon.Address]bool
+	freeSources   map[string]bool // "fromChainId:hex" of the source contract, without 0x
+	maticPrice    *big.Float
+}
+
+// NewFeePolicy builds the policy of a validated config. Without config,
+// paid transfers are relayed and unpaid ones rejected.
+func NewFeePolicy(cfg *config.FeePolicyConfig) *FeePolicy {
+	if cfg == nil {
+		cfg = &config.FeePolicyConfig{
+			UnpaidAction:  config.FEE_ACTION_REJECT,
+			LowFeeAction:  config.FEE_ACTION_DEFER,
+			DeferInterval: config.DEFAULT_FEE_DEFER_INTERVAL,
+		}
+	}
+	this := &FeePolicy{
+		config:        cfg,
+		minFee:        make(map[string]*big.Float),
+		freeContracts: make(map[ethcommon.Address]bool),
+		freeSources:   make(map[string]bool),
+	}
+	for k, v := range cfg.MinFee {
+		fee, _ := new(big.Float).SetString(v)
+		if chain := strings.SplitN(k, ":", 2); len(chain) == 2 {
+			k = fmt.Sprintf("%s:%s", chain[0], strings.ToLower(ethcommon.HexToAddress(chain[1]).Hex()))
+		}
+		this.minFee[k] = fee
+	}
+	for _, v := range cfg.FreeContracts {
+		this.freeContracts[ethcommon.HexToAddress(v)] = true
+	}
+	for _, v := range cfg.FreeSources {
+		chain := strings.SplitN(v, ":", 2)
+		this.freeSources[chain[0]+":"+strings.ToLower(strings.TrimPrefix(chain[1], "0x"))] = true
+	}
+	if cfg.GasCostRatio > 0 {
+		this.maticPrice, _ = new(big.Float).SetString(cfg.MaticPrice)
+	}
+	return this
+}
+
+// Free tells whether tx is relayed without checking its fee
+func (this *FeePolicy) Free(tx *BridgeTransaction) (bool, string) {
+	to := ethcommon.BytesToAddress(tx.param.MakeTxParam.ToContractAddress)
+	if this.freeContracts[to] {
+		return true, fmt.Sprintf("target contract %s is free", to.Hex())
+	}
+	from := fmt.Sprintf("%d:%s", tx.param.FromChainID, hex.EncodeToString(tx.param.MakeTxParam.FromContractAddress))
+	if this.freeSources[from] {
+		return true, fmt.Sprintf("source contract %s is free", from)
+	}
+	return false, ""
+}
+
+// Unpaid decides about a transfer whose fee is not paid
+func (this *FeePolicy) Unpaid(tx *BridgeTransaction) (FeeDecision, string) {
+	return this.action(this.config.UnpaidAction), "fee not paid"
+}
+
+// Paid decides about a transfer paying fee. gasCost estimates the bor gas
+// cost of relaying it in wei, it is only called if the policy needs it.
+func (this *FeePolicy) Paid(tx *BridgeTransaction, fee string, gasCost func() (*big.Int, error)) (FeeDecision, string) {
+	paid, ok := new(big.Float).SetString(fee)
+	if !ok {
+		return this.action(this.config.LowFeeAction), fmt.Sprintf("fee %q is not a number", fee)
+	}
+	if min, key := this.minFeeOf(tx); min != nil && paid.Cmp(min) < 0 {
+		return this.action(this.config.LowFeeAction), fmt.Sprintf("fee %s below minimum %s of %s", fee, min.String(), key)
+	}
+	if this.maticPrice == nil {
+		return FEE_RELAY, ""
+	}
+	cost, err := gasCost()
+	if err != nil {
+		// sending will fail the same way and be retried, no reason to hold it here
+		return FEE_RELAY, fmt.Sprintf("gas cost unknown: %s", err)
+	}
+	required := new(big.Float).Quo(new(big.Float).SetInt(cost), maticWei)
+	required.Mul(required, this.maticPrice)
+	required.Mul(required, big.NewFloat(this.config.GasCostRatio))
+	if paid.Cmp(required) < 0 {
+		return this.action(this.config.LowFeeAction), fmt.Sprintf("fee %s below %s for %s wei of gas", fee, required.Text('f', 6), cost.String())
+	}
+	return FEE_RELAY, ""
+}
+
+func (this *FeePolicy) minFeeOf(tx *BridgeTransaction) (*big.Float, string) {
+	chain := strconv.FormatUint(tx.param.FromChainID, 10)
+	to := strings.ToLower(ethcommon.BytesToAddress(tx.param.MakeTxParam.ToContractAddress).Hex())
+	for _, k := range []string{chain + ":" + to, chain, "*"} {
+		if min, ok := this.minFee[k]; ok {
+			return min, k
+		}
+	}
+	return nil, ""
+}
+
+func (this *FeePolicy) action(action string) FeeDecision {
+	if action == config.FEE_ACTION_DEFER {
+		return FEE_DEFER
+	}
+	return FEE_REJECT
+}
+
+// DeferInterval is how long a deferred transfer waits before its fee is
+// checked again
+func (this *FeePolicy) DeferInterval() time.Duration {
+	return time.Duration(this.config.DeferInterval) * time.Second
+}
+
+// DeferExpired tells whether tx has waited too long to be deferred again
+func (this *FeePolicy) DeferExpired(tx *BridgeTransaction, now time.Time) bool {
+	return this.config.MaxDefer > 0 && uint64(now.Unix()) > tx.enqueuedAt+this.config.MaxDefer
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/polynetwork/poly/common"
+	polytypes "github.com/polynetwork/poly/core/types"
+	common2 "github.com/polynetwork/poly/native/service/cross_chain_manager/common"
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
+	"github.com/polynetwork/polygon-relayer/tools"
+)
+
+const (
+	POLY_RETRY_BACKOFF        = 2 * time.Second // wait before handling a failed poly block again, doubled on each failure
+	POLY_RETRY_BACKOFF_MAX    = 2 * time.Minute
+	POLY_BLOCK_STUCK_FAILURES = 10 // failures of a poly block raising ALERT_POLY_BLOCK_STUCK
+
+	METRIC_POLY_BLOCK_FAILURES = "poly.block_failures"
+	METRIC_POLY_SCAN_HEIGHT    = "poly.scan_height"
+
+	ALERT_POLY_BLOCK_STUCK = "poly_block_stuck"
+
+	STATUS_POLY_SCAN = "poly_scan"
+
+	// ProofError.Step, the steps building the proof of a poly to bor transfer
+	PROOF_STEP_HEADER       = "header"             // next header, holding the cross states root of the block
+	PROOF_STEP_EPOCH        = "epoch height"       // epoch start height of the ECCM
+	PROOF_STEP_ANCHOR       = "anchor header"      // first header of the ECCM epoch
+	PROOF_STEP_HEADER_PROOF = "header proof"       // merkle proof of the header in the anchor
+	PROOF_STEP_STATES_PROOF = "cross states proof" // proof of the transfer in the header
+	PROOF_STEP_AUDIT_PATH   = "audit path"
+	PROOF_STEP_MERKLE_VALUE = "merkle value" // the transfer itself
+)
+
+// ProofError is returned when the proof of the transfers of a poly block
+// cannot be built, the block is handled again
+type ProofError struct {
+	Step   string // PROOF_STEP_*
+	Height uint32 // poly block
+	Key    string // cross states key of the transfer, if any
+	Err    error
+}
+
+func (this *ProofError) Error() string {
+	if this.Key != "" {
+		return fmt.Sprintf("%s of poly block %d, key %s: %s", this.Step, this.Height, this.Key, this.Err)
+	}
+	return fmt.Sprintf("%s of poly block %d: %s", this.Step, this.Height, this.Err)
+}
+
+func (this *ProofError) Unwrap() error {
+	return this.Err
+}
+
+// anchorProof returns the anchor header of the ECCM epoch and the proof of
+// header height in it
+func (this *PolyManager) anchorProof(blockHeight uint32, height uint32, lastEpoch uint32) (*polytypes.Header, string, error) {
+	anchor, err := this.polySdk.GetHeaderByHeight(lastEpoch + 1)
+	if err != nil {
+		return nil, "", &ProofError{Step: PROOF_STEP_ANCHOR, Height: blockHeight, Err: err}
+	}
+	if anchor == nil {
+		return nil, "", &ProofError{Step: PROOF_STEP_ANCHOR, Height: blockHeight, Err: fmt.Errorf("no header at %d", lastEpoch+1)}
+	}
+	proof, err := this.polySdk.GetMerkleProof(height, lastEpoch+1)
+	if err != nil {
+		return nil, "", &ProofError{Step: PROOF_STEP_HEADER_PROOF, Height: blockHeight, Err: err}
+	}
+	if proof == nil || proof.AuditPath == "" {
+		return nil, "", &ProofError{Step: PROOF_STEP_HEADER_PROOF, Height: blockHeight, Err: fmt.Errorf("empty proof of %d in %d", height, lastEpoch+1)}
+	}
+	return anchor, proof.AuditPath, nil
+}
+
+// crossStatesProof returns the audit path of the transfer stored at key as of
+// header height, and the transfer
+func (this *PolyManager) crossStatesProof(blockHeight uint32, height uint32, key string) ([]byte, *common2.ToMerkleValue, error) {
+	proof, err := this.polySdk.GetCrossStatesProof(height, key)
+	if err != nil {
+		return nil, nil, &ProofError{Step: PROOF_STEP_STATES_PROOF, Height: blockHeight, Key: key, Err: err}
+	}
+	if proof == nil {
+		return nil, nil, &ProofError{Step: PROOF_STEP_STATES_PROOF, Height: blockHeight, Key: key, Err: fmt.Errorf("no proof")}
+	}
+	auditpath, err := hex.DecodeString(proof.AuditPath)
+	if err != nil {
+		return nil, nil, &ProofError{Step: PROOF_STEP_AUDIT_PATH, Height: blockHeight, Key: key, Err: err}
+	}
+	value, _, _, err := tools.ParseAuditpath(auditpath)
+	if err != nil {
+		return nil, nil, &ProofError{Step: PROOF_STEP_AUDIT_PATH, Height: blockHeight, Key: key, Err: err}
+	}
+	if len(value) == 0 {
+		return nil, nil, &ProofError{Step: PROOF_STEP_AUDIT_PATH, Height: blockHeight, Key: key, Err: fmt.Errorf("truncated audit path %x", auditpath)}
+	}
+	param := &common2.ToMerkleValue{}
+	if err := param.Deserialization(common.NewZeroCopySource(value)); err != nil {
+		return nil, nil, &ProofError{Step: PROOF_STEP_MERKLE_VALUE, Height: blockHeight, Key: key, Err: fmt.Errorf("value %x: %s", value, err)}
+	}
+	return auditpath, param, nil
+}
+
+// PolyScanStatus is published at /status of the admin address
+type PolyScanStatus struct {
+	Height       uint32     // next poly block to handle
+	Failures     int        // failures of that block in a row
+	LastError    string     `json:",omitempty"`
+	FailedStep   string     `json:",omitempty"` // proof step that failed, if any
+	FailingSince *time.Time `json:",omitempty"`
+	RetryAt      *time.Time `json:",omitempty"`
+}
+
+// polyScanRetry paces the poly scan when a block keeps failing
+type polyScanRetry struct {
+	failures int
+	since    time.Time
+	retryAt  time.Time
+}
+
+// wait tells whether the failed block must not be handled yet
+func (this *polyScanRetry) wait() bool {
+	return this.failures > 0 && time.Now().Before(this.retryAt)
+}
+
+func (this *PolyManager) blockHandled(height uint32) {
+	if this.scanRetry.failures > 0 {
+		log.Infof("MonitorChain - poly block %d handled after %d failures", height, this.scanRetry.failures)
+		metrics.Resolve(ALERT_POLY_BLOCK_STUCK)
+	}
+	this.scanRetry = polyScanRetry{}
+	metrics.Set(METRIC_POLY_SCAN_HEIGHT, int64(height))
+	metrics.SetStatus(STATUS_POLY_SCAN, &PolyScanStatus{Height: height + 1})
+}
+
+func (this *PolyManager) blockFailed(height uint32, err error) {
+	now := time.Now()
+	retry := &this.scanRetry
+	if retry.failures == 0 {
+		retry.since = now
+	}
+	retry.failures++
+	backoff := POLY_RETRY_BACKOFF << uint(retry.failures-1)
+	if backoff > POLY_RETRY_BACKOFF_MAX || backoff <= 0 {
+		backoff = POLY_RETRY_BACKOFF_MAX
+	}
+	retry.retryAt = now.Add(backoff)
+	metrics.Add(METRIC_POLY_BLOCK_FAILURES, 1)
+
+	status := &PolyScanStatus{
+		Height:    height,
+		Failures:  retry.failures,
+		LastError: err.Error(),
+	}
+	since, retryAt := retry.since, retry.retryAt
+	status.FailingSince, status.RetryAt = &since, &retryAt
+	var proofErr *ProofError
+	if errors.As(err, &proofErr) {
+		status.FailedStep = proofErr.Step
+	}
+	metrics.SetStatus(STATUS_POLY_SCAN, status)
+
+	log.Errorf("MonitorChain - handle poly block %d error, retry it in %s: %s", height, backoff, err)
+	if retry.failures >= POLY_BLOCK_STUCK_FAILURES {
+		metrics.Alert(ALERT_POLY_BLOCK_STUCK, "poly block %d failed %d times since %s: %s",
+			height, retry.failures, retry.since.Format(time.RFC3339), err)
+	}
+}
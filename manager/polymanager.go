@@ -21,6 +21,7 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -45,27 +46,32 @@ import (
 	"github.com/polynetwork/polygon-relayer/config"
 	"github.com/polynetwork/polygon-relayer/db"
 	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
 	sdk "github.com/polynetwork/polygon-relayer/poly_go_sdk"
+	mytypes "github.com/polynetwork/polygon-relayer/types"
 
 	"math/big"
 	"time"
 
-	"poly_bridge_sdk"
-
 	"github.com/ethereum/go-ethereum"
 	"github.com/polynetwork/polygon-relayer/tools"
+	"golang.org/x/crypto/ssh/terminal"
 
 	polytypes "github.com/polynetwork/poly/core/types"
 )
 
 const (
 	ChanLen = 0
+
+	METRIC_POLY_INVALID_NOTIFIES = "poly.invalid_notifies" // makeProof notifies that could not be decoded
 )
 
 const (
 	FEE_NOCHECK = iota
 	FEE_HASPAY
 	FEE_NOTPAY
+	FEE_FREE     // relayed without fee, see FeePolicy.Free
+	FEE_DEFERRED // fee checked again later, see FeePolicy
 )
 
 type BridgeTransaction struct {
@@ -77,6 +83,9 @@ type BridgeTransaction struct {
 	rawAuditPath []byte
 	hasPay       uint8
 	fee          string
+	enqueuedAt   uint64 // unix time it was first queued, see BridgeQueue
+	reason       string // why the fee policy deferred or rejected it
+	decidedAt    uint64 // unix time of the last fee policy decision
 }
 
 func (this *BridgeTransaction) Serialization(sink *common.ZeroCopySink) {
@@ -93,6 +102,9 @@ func (this *BridgeTransaction) Serialization(sink *common.ZeroCopySink) {
 	sink.WriteVarBytes(this.rawAuditPath)
 	sink.WriteUint8(this.hasPay)
 	sink.WriteString(this.fee)
+	sink.WriteUint64(this.enqueuedAt)
+	sink.WriteString(this.reason)
+	sink.WriteUint64(this.decidedAt)
 }
 
 func (this *BridgeTransaction) Deserialization(source *common.ZeroCopySource) error {
@@ -134,6 +146,23 @@ func (this *BridgeTransaction) Deserialization(source *common.ZeroCopySource) er
 	if eof {
 		return fmt.Errorf("Waiting deserialize fee error")
 	}
+	// left out by older relayers
+	if source.Len() > 0 {
+		this.enqueuedAt, eof = source.NextUint64()
+		if eof {
+			return fmt.Errorf("Waiting deserialize enqueued time error")
+		}
+	}
+	if source.Len() > 0 {
+		this.reason, eof = source.NextString()
+		if eof {
+			return fmt.Errorf("Waiting deserialize reason error")
+		}
+		this.decidedAt, eof = source.NextUint64()
+		if eof {
+			return fmt.Errorf("Waiting deserialize decision time error")
+		}
+	}
 	return nil
 }
 
@@ -151,9 +180,15 @@ type PolyManager struct {
 	db            *db.BoltDB
 	ethClient     *ethclient.Client
 	senders       []*EthSender
-	bridgeSdk     *poly_bridge_sdk.BridgeFeeCheck
+	feeChecker    FeeChecker
 	eccdInstance  *eccd_abi.EthCrossChainData
 	nofeemode     bool
+	queue         *BridgeQueue
+	epochs        *EpochTracker
+	scanRetry     polyScanRetry
+	feePolicy     *FeePolicy
+	contracts     *ContractMatcher
+	valueCaps     *ValueCaps
 
 	txChan    chan *BridgeTransactionAndHash
 	txSenChan chan *EthSender
@@ -165,6 +200,8 @@ func NewPolyManager(servCfg *config.ServiceConfig,
 	polySdk *sdk.PolySdk,
 	ethereumsdk *ethclient.Client,
 	boltDB *db.BoltDB,
+	contracts *ContractMatcher,
+	valueCaps *ValueCaps,
 	nofeemode bool) (polyManager *PolyManager, err error) {
 	contractabi, err := abi.JSON(strings.NewReader(eccm_abi.EthCrossChainManagerABI))
 	if err != nil {
@@ -179,23 +216,44 @@ func NewPolyManager(servCfg *config.ServiceConfig,
 	if err != nil {
 		return nil, err
 	}
-	ks := tools.NewEthKeyStore(servCfg.ETHConfig, chainId)
-	accArr := ks.GetAccounts()
-	if len(servCfg.ETHConfig.KeyStorePwdSet) == 0 {
-		fmt.Println("please input the passwords for ethereum keystore: ")
-		for _, v := range accArr {
-			fmt.Printf("For address %s. ", v.Address.String())
+	var signer tools.EthSigner
+	if servCfg.ETHConfig.SignerURL != "" {
+		if signer, err = tools.NewRemoteEthSigner(servCfg.ETHConfig.SignerURL, chainId); err != nil {
+			return nil, err
+		}
+	} else {
+		ks := tools.NewEthKeyStore(servCfg.ETHConfig, chainId)
+		if servCfg.ETHConfig.KeyStorePwdSet == nil {
+			servCfg.ETHConfig.KeyStorePwdSet = make(map[string]string)
+		}
+		for _, v := range ks.GetAccounts() {
+			addr := strings.ToLower(v.Address.String())
+			if _, ok := servCfg.ETHConfig.KeyStorePwdSet[addr]; ok {
+				continue
+			}
+			if pwd, ok := config.KeyStorePwdFromEnv(addr); ok {
+				servCfg.ETHConfig.KeyStorePwdSet[addr] = pwd
+				continue
+			}
+			// no one to answer a prompt in a container
+			if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+				return nil, fmt.Errorf("NewPolyManager - no password for ethereum account %s, set it in KeyStorePwdSet or %s",
+					v.Address.String(), config.ENV_KEYSTORE_PWD)
+			}
+			fmt.Printf("please input the password for ethereum keystore address %s: ", v.Address.String())
 			raw, err := password.GetPassword()
 			if err != nil {
 				log.Fatalf("failed to input password: %v", err)
 				panic(err)
 			}
-			servCfg.ETHConfig.KeyStorePwdSet[strings.ToLower(v.Address.String())] = string(raw)
+			servCfg.ETHConfig.KeyStorePwdSet[addr] = string(raw)
 		}
+		if err = ks.UnlockKeys(servCfg.ETHConfig); err != nil {
+			return nil, err
+		}
+		signer = ks
 	}
-	if err = ks.UnlockKeys(servCfg.ETHConfig); err != nil {
-		return nil, err
-	}
+	accArr := signer.GetAccounts()
 
 	senders := make([]*EthSender, len(accArr))
 	for i, v := range senders {
@@ -203,7 +261,7 @@ func NewPolyManager(servCfg *config.ServiceConfig,
 		v.acc = accArr[i]
 
 		v.ethClient = ethereumsdk
-		v.keyStore = ks
+		v.signer = signer
 		v.config = servCfg
 		v.polySdk = polySdk
 		v.contractAbi = &contractabi
@@ -221,8 +279,13 @@ func NewPolyManager(servCfg *config.ServiceConfig,
 		txSenChan <- v
 	}
 
-	bridgeSdk := poly_bridge_sdk.NewBridgeFeeCheck(servCfg.BridgeUrl, 5)
-	return &PolyManager{
+	queue, err := NewBridgeQueue(boltDB, nofeemode)
+	if err != nil {
+		return nil, err
+	}
+
+	feeChecker := NewFeeChecker(servCfg.BridgeUrl, time.Duration(servCfg.FeeCheckTTL)*time.Second)
+	polyManager = &PolyManager{
 		exitChan:      make(chan int),
 		config:        servCfg,
 		polySdk:       polySdk,
@@ -231,16 +294,22 @@ func NewPolyManager(servCfg *config.ServiceConfig,
 		db:            boltDB,
 		ethClient:     ethereumsdk,
 		senders:       senders,
-		bridgeSdk:     bridgeSdk,
+		feeChecker:    feeChecker,
 		eccdInstance:  instance,
 
 		nofeemode: nofeemode,
+		queue:     queue,
+		feePolicy: NewFeePolicy(servCfg.FeePolicy),
+		contracts: contracts,
+		valueCaps: valueCaps,
 
 		txChan:    make(chan *BridgeTransactionAndHash, 4),
 		txSenChan: txSenChan,
 		txLock:   &sync.Mutex{},
 		
-	}, nil
+	}
+	polyManager.epochs = NewEpochTracker(instance, polyManager.selectSender)
+	return polyManager, nil
 }
 
 func (this *PolyManager) findLatestHeight() uint32 {
@@ -275,7 +344,6 @@ func (this *PolyManager) MonitorChain() {
 		log.Errorf("MonitorChain - init failed\n")
 	}
 	monitorTicker := time.NewTicker(config.ETH_MONITOR_INTERVAL)
-	var blockHandleResult bool
 	for {
 		select {
 		case <-monitorTicker.C:
@@ -288,18 +356,21 @@ func (this *PolyManager) MonitorChain() {
 			if latestheight-this.currentHeight < config.ONT_USEFUL_BLOCK_NUM {
 				continue
 			}
+			if this.scanRetry.wait() {
+				continue
+			}
 			log.Infof("MonitorChain - poly chain current height: %d", latestheight)
-			blockHandleResult = true
 			for this.currentHeight <= latestheight-config.ONT_USEFUL_BLOCK_NUM {
-				blockHandleResult = this.handleDepositEvents(this.currentHeight)
-				if blockHandleResult == false {
+				if err = this.handleDepositEvents(this.currentHeight); errors.Is(err, mytypes.ErrEpochNotSynced) {
+					log.Debugf("MonitorChain - poly block %d waits for its epoch change: %s", this.currentHeight, err)
+					break
+				} else if err != nil {
+					this.blockFailed(this.currentHeight, err)
 					break
 				}
+				this.blockHandled(this.currentHeight)
 				this.currentHeight++
 			}
-			if err = this.db.UpdatePolyHeight(this.currentHeight - 1); err != nil {
-				log.Errorf("MonitorChain - failed to save height of poly: %v", err)
-			}
 		case <-this.exitChan:
 			return
 		}
@@ -340,89 +411,77 @@ func (this *PolyManager) IsEpoch(hdr *polytypes.Header) (bool, []byte, error) {
 	return true, publickeys, nil
 }
 
-func (this *PolyManager) handleDepositEvents(height uint32) bool {
-	lastEpoch := this.findLatestHeight()
+// provenByCurrentEpoch tells whether a header at hdrHeight is checked by the
+// ECCM against its bookkeepers of the epoch started at lastEpoch, without an
+// anchor header. An epoch change header is not: the old bookkeepers sign it,
+// while the ECCM checks it against the new ones once it is synced, so it is
+// proven from the header at lastEpoch+1.
+func provenByCurrentEpoch(hdrHeight uint32, lastEpoch uint32) bool {
+	return lastEpoch < hdrHeight
+}
+
+// handleDepositEvents queues the transfers to bor of poly block height and
+// moves the poly height past it, the block must be handled again on error
+func (this *PolyManager) handleDepositEvents(height uint32) error {
 	hdr, err := this.polySdk.GetHeaderByHeight(height + 1)
 	if err != nil {
-		log.Errorf("handleDepositEvents - GetNodeHeader on height :%d failed", height)
-		return false
+		return &ProofError{Step: PROOF_STEP_HEADER, Height: height, Err: err}
 	}
-	isCurr := lastEpoch < height+1
 	isEpoch, pubkList, err := this.IsEpoch(hdr)
 	if err != nil {
-		log.Errorf("falied to check isEpoch: %v", err)
-		return false
+		return fmt.Errorf("check isEpoch: %w", err)
+	}
+	lastEpoch, err := this.epochs.Current()
+	if err != nil {
+		return &ProofError{Step: PROOF_STEP_EPOCH, Height: height, Err: err}
+	}
+	if isEpoch && lastEpoch < height+1 {
+		// the transfers of this block on are proven against the new epoch
+		if err = this.epochs.Sync(hdr, pubkList); err != nil {
+			return err
+		}
+		if lastEpoch, err = this.epochs.Current(); err != nil {
+			return &ProofError{Step: PROOF_STEP_EPOCH, Height: height, Err: err}
+		}
 	}
 	var (
 		anchor *polytypes.Header
 		hp     string
 	)
-	if !isCurr {
-		anchor, _ = this.polySdk.GetHeaderByHeight(lastEpoch + 1)
-		proof, _ := this.polySdk.GetMerkleProof(height+1, lastEpoch+1)
-		hp = proof.AuditPath
-	} else if isEpoch {
-		anchor, _ = this.polySdk.GetHeaderByHeight(height + 2)
-		proof, _ := this.polySdk.GetMerkleProof(height+1, height+2)
-		hp = proof.AuditPath
+	if !provenByCurrentEpoch(hdr.Height, lastEpoch) {
+		if anchor, hp, err = this.anchorProof(height, height+1, lastEpoch); err != nil {
+			return err
+		}
 	}
 
-	cnt := 0
+	txs := make([]*BridgeTransaction, 0)
+	letters := make([]*db.DeadLetter, 0)
+	held := make([]*db.DeadLetter, 0)
+	contracts := this.contracts.Batch()
+	valueCaps := this.valueCaps.Batch()
 	events, err := this.polySdk.GetSmartContractEventByBlock(height)
-	for err != nil {
-		log.Errorf("handleDepositEvents - get block event at height:%d error: %s", height, err.Error())
-		return false
+	if err != nil {
+		return fmt.Errorf("GetSmartContractEventByBlock %d: %w", height, err)
 	}
 	for _, event := range events {
 		for _, notify := range event.Notify {
 			if notify.ContractAddress == this.config.PolyConfig.EntranceContractAddress {
-				states := notify.States.([]interface{})
-				method, _ := states[0].(string)
-				if method != "makeProof" {
-					continue
-				}
-				if uint64(states[2].(float64)) != this.config.ETHConfig.SideChainId {
+				if mytypes.NotifyMethod(notify.States) != mytypes.NOTIFY_MAKE_PROOF {
 					continue
 				}
-				proof, err := this.polySdk.GetCrossStatesProof(hdr.Height-1, states[5].(string))
+				makeProof, err := mytypes.DecodeMakeProofNotify(notify.States)
 				if err != nil {
-					log.Errorf("handleDepositEvents - failed to get proof for key %s: %v", states[5].(string), err)
+					log.Errorf("handleDepositEvents - poly tx %s at height %d: %s", event.TxHash, height, err)
+					metrics.Add(METRIC_POLY_INVALID_NOTIFIES, 1)
 					continue
 				}
-				auditpath, _ := hex.DecodeString(proof.AuditPath)
-				value, _, _, _ := tools.ParseAuditpath(auditpath)
-				param := &common2.ToMerkleValue{}
-				if err := param.Deserialization(common.NewZeroCopySource(value)); err != nil {
-					log.Errorf("handleDepositEvents - failed to deserialize MakeTxParam (value: %x, err: %v)", value, err)
+				if makeProof.ToChainID != this.config.ETHConfig.SideChainId {
 					continue
 				}
-				var isTarget bool
-				if len(this.config.TargetContracts) > 0 {
-					toContractStr := ethcommon.BytesToAddress(param.MakeTxParam.ToContractAddress).String()
-					for _, v := range this.config.TargetContracts {
-						toChainIdArr, ok := v[toContractStr]
-						if ok {
-							if len(toChainIdArr["inbound"]) == 0 {
-								isTarget = true
-								break
-							}
-							for _, id := range toChainIdArr["inbound"] {
-								if id == param.FromChainID {
-									isTarget = true
-									break
-								}
-							}
-							if isTarget {
-								break
-							}
-						}
-					}
-					if !isTarget {
-						continue
-					}
+				auditpath, param, err := this.crossStatesProof(height, hdr.Height-1, makeProof.Key)
+				if err != nil {
+					return err
 				}
-				cnt++
-				//
 				bridgeTransaction := &BridgeTransaction{
 					header:       hdr,
 					param:        param,
@@ -433,9 +492,22 @@ func (this *PolyManager) handleDepositEvents(height uint32) bool {
 					hasPay:       FEE_NOCHECK,
 					fee:          "0",
 				}
-				sink := common.NewZeroCopySink(nil)
-				bridgeTransaction.Serialization(sink)
-				this.db.PutBridgeTransactions(fmt.Sprintf("%d%s", param.FromChainID, hex.EncodeToString(param.MakeTxParam.TxHash)), sink.Bytes())
+				toContract := ethcommon.BytesToAddress(param.MakeTxParam.ToContractAddress)
+				switch decision, reason := contracts.Match(config.TARGET_INBOUND, toContract, param.FromChainID); decision {
+				case CONTRACT_DENIED:
+					log.Debugf("handleDepositEvents - skip poly tx %s: %s", event.TxHash, reason)
+					continue
+				case CONTRACT_RATE_LIMITED:
+					log.Warnf("handleDepositEvents - dead-letter poly tx %s: %s", event.TxHash, reason)
+					letters = append(letters, bridgeTransactionLetter(bridgeTransaction, reason))
+					continue
+				}
+				if isHeld, reason := valueCaps.Check(toContract, this.config.ETHConfig.SideChainId, param.MakeTxParam.Args); isHeld {
+					log.Warnf("handleDepositEvents - hold poly tx %s: %s", event.TxHash, reason)
+					held = append(held, bridgeTransactionLetter(bridgeTransaction, reason))
+					continue
+				}
+				txs = append(txs, bridgeTransaction)
 				log.Infof("cross chain transactions, from chain id: %d, poly tx: %s, src tx: %s",
 					param.FromChainID, hex.EncodeToString(tools.HexReverse(param.TxHash)), hex.EncodeToString(param.MakeTxParam.TxHash))
 				//if !sender.commitDepositEventsWithHeader(hdr, param, hp, anchor, event.TxHash, auditpath) {
@@ -444,16 +516,16 @@ func (this *PolyManager) handleDepositEvents(height uint32) bool {
 			}
 		}
 	}
-	if cnt == 0 && isEpoch && isCurr {
-		sender := this.selectSender()
-		if sender == nil {
-			log.Info("There is not sender......")
-			return false
-		}
-		return sender.commitHeader(hdr, pubkList)
+	if err := this.queue.PushBlock(height, txs, letters, held); err != nil {
+		return fmt.Errorf("store %d transfers: %w", len(txs), err)
 	}
-
-	return true
+	contracts.Commit()
+	valueCaps.Commit()
+	metrics.Add(METRIC_DEAD_LETTERS, int64(len(letters)))
+	for _, v := range held {
+		transferHeld(v)
+	}
+	return nil
 }
 
 func (this *PolyManager) selectSender1() *EthSender {
@@ -519,172 +591,35 @@ func (this *PolyManager) MonitorDeposit() {
 }
 
 func (this *PolyManager) handleLockDepositEvents() error {
-	retryList, err := this.db.GetAllBridgeTransactions()
-	if err != nil {
-		return fmt.Errorf("handleLockDepositEvents - this.db.GetAllBridgeTransactions error: %s", err)
-	}
-	log.Infof("handleLockDepositEvents - start, len: %d", len(retryList))
-	if len(retryList) == 0 {
-		return nil
-	}
-	bridgeTransactions := make(map[string]*BridgeTransaction)
-	for _, v := range retryList {
-		bridgeTransaction := new(BridgeTransaction)
-		err := bridgeTransaction.Deserialization(common.NewZeroCopySource(v))
-		if err != nil {
-			log.Errorf("handleLockDepositEvents - retry.Deserialization error: %s", err)
-			continue
-		}
-		bridgeTransactions[fmt.Sprintf("%d%s", bridgeTransaction.param.FromChainID, hex.EncodeToString(bridgeTransaction.param.MakeTxParam.TxHash))] = bridgeTransaction
-	}
-	noCheckFees := make([]*poly_bridge_sdk.CheckFeeReq, 0)
-	for _, v := range bridgeTransactions {
-		if v.hasPay == FEE_NOCHECK {
-			noCheckFees = append(noCheckFees, &poly_bridge_sdk.CheckFeeReq{
-				ChainId: v.param.FromChainID,
-				Hash:    hex.EncodeToString(v.param.MakeTxParam.TxHash),
-			})
-		}
-	}
-
-	var checkfeeRes string
-	if len(noCheckFees) > 0 {
-		checkFees, err := this.checkFee(noCheckFees)
-		if err != nil {
-			log.Errorf("handleLockDepositEvents - checkFee error: %s", err)
-		}
-
-		for _, checkFee := range checkFees {
-			if checkFee.Error != "" {
-				log.Errorf("check fee err: %s", checkFee.Error)
-				continue
-			}
-			item, ok := bridgeTransactions[fmt.Sprintf("%d%s", checkFee.ChainId, checkFee.Hash)]
-			if ok {
-				if checkFee.PayState == poly_bridge_sdk.STATE_HASPAY {
-					log.Infof("tx(%d,%s) has payed fee", checkFee.ChainId, checkFee.Hash)
-					item.hasPay = FEE_HASPAY
-					item.fee = checkFee.Amount
-				} else if checkFee.PayState == poly_bridge_sdk.STATE_NOTPAY {
-					log.Infof("tx(%d,%s) has not payed fee", checkFee.ChainId, checkFee.Hash)
-					item.hasPay = FEE_NOTPAY
-				} else {
-					log.Warnf("check fee of tx(%d,%s) failed", checkFee.ChainId, checkFee.Hash)
-				}
-			}
-		}
+	this.checkQueuedFees()
 
-		bb, _ := json.Marshal(checkFees)
-		checkfeeRes = string(bb)
-	}
-	if !this.nofeemode {
-		for k, v := range bridgeTransactions {
-			if v.hasPay == FEE_NOTPAY {
-				log.Infof("tx (src %d, %s, poly %s) has not pay proxy fee, ignore it, payed: %s, total response: %s",
-					v.param.FromChainID, hex.EncodeToString(v.param.MakeTxParam.TxHash), v.polyTxHash, v.fee, checkfeeRes)
-
-				this.db.DeleteBridgeTransactions(k)
-				delete(bridgeTransactions, k)
-			}
-		}
+	sortedTx := this.queue.PopReady()
+	log.Infof("handleLockDepositEvents - start, ready: %d, queued: %d", len(sortedTx), this.queue.Len())
+	if len(sortedTx) == 0 {
+		return nil
 	}
-	retryBridgeTransactions := make(map[string]*BridgeTransaction)
 
-	// txChan := make(chan *BridgeTransactionAndHash, len(bridgeTransactions))
 	txSend := make([][]*BridgeTransactionAndHash, len(this.senders))
-	for i:=0;i<len(this.senders);i++ {
+	for i := 0; i < len(this.senders); i++ {
 		txSend[i] = make([]*BridgeTransactionAndHash, 0)
 	}
-
-	log.Infof("wg.Wait start start 1")
-	sortedTx := make([]*BridgeTransactionAndHash, len(bridgeTransactions))
-	for len(bridgeTransactions) > 0 {
-		var maxFeeOfTransaction *BridgeTransaction = nil
-		maxFee := new(big.Float).SetUint64(0)
-		maxFeeOfTxHash := ""
-
-		log.Infof("select transaction")
-
-		for k, v := range bridgeTransactions {
-
-			fee, result := new(big.Float).SetString(v.fee)
-			if !result {
-				log.Errorf("fee is invalid")
-				delete(bridgeTransactions, maxFeeOfTxHash)
-				continue
-			}
-
-			if (v.hasPay == FEE_HASPAY && fee.Cmp(maxFee) > 0) || this.nofeemode {
-				maxFee = fee
-				maxFeeOfTransaction = v
-				maxFeeOfTxHash = k
-			}
-		}
-
-		if maxFeeOfTransaction != nil {
-
-			log.Infof("select transaction, poly txhash: %s, poly txhash2: %s", maxFeeOfTransaction.polyTxHash, hex.EncodeToString(tools.HexReverse(maxFeeOfTransaction.param.TxHash)))
-
-			/* txChan <- &BridgeTransactionAndHash{
-				BridgeTransaction: maxFeeOfTransaction,
-				Hash:              maxFeeOfTxHash,
-			} */
-
-			sortedTx = append(sortedTx, &BridgeTransactionAndHash{
-				BridgeTransaction: maxFeeOfTransaction,
-				Hash:              maxFeeOfTxHash,
-			})
-
-			delete(bridgeTransactions, maxFeeOfTxHash)
-
-			/* 			sender := this.selectSender()
-			   			if sender == nil {
-			   				log.Infof("There is no sender.......")
-			   				return nil
-			   			}
-			   			log.Infof("sender %s is handling poly tx (hash: %s)", sender.acc.Address.String(), hex.EncodeToString(tools.HexReverse(maxFeeOfTransaction.param.TxHash)))
-			   			res := sender.commitDepositEventsWithHeader(maxFeeOfTransaction.header,
-			   				maxFeeOfTransaction.param,
-			   				maxFeeOfTransaction.headerProof,
-			   				maxFeeOfTransaction.anchorHeader,
-			   				hex.EncodeToString(maxFeeOfTransaction.param.TxHash),
-			   				maxFeeOfTransaction.rawAuditPath)
-
-			   			log.Infof("sender %s tx return tx (hash: %s)", sender.acc.Address.String(), hex.EncodeToString(tools.HexReverse(maxFeeOfTransaction.param.TxHash)))
-			   			if res {
-			   				this.db.DeleteBridgeTransactions(maxFeeOfTxHash)
-			   			} else {
-			   				retryBridgeTransactions[maxFeeOfTxHash] = maxFeeOfTransaction
-			   			}
-
-			   			delete(bridgeTransactions, maxFeeOfTxHash) */
-		} else {
-			break
-		}
-	}
-	//close(txChan)
-	for i,v := range sortedTx {
-		if v != nil {
-			txSend[i%len(this.senders)] = append(txSend[i%len(this.senders)], v)
-			log.Infof("txSend %d, v: %w", i%len(this.senders), v)
-		}
+	for i, v := range sortedTx {
+		log.Infof("select transaction, poly txhash: %s, poly txhash2: %s, fee: %s", v.BridgeTransaction.polyTxHash,
+			hex.EncodeToString(tools.HexReverse(v.BridgeTransaction.param.TxHash)), v.BridgeTransaction.fee)
+		txSend[i%len(this.senders)] = append(txSend[i%len(this.senders)], v)
 	}
 
 	var wg sync.WaitGroup
 	for i := 0; i < len(this.senders); i++ {
 		wg.Add(1)
 		log.Infof("wg.Wait create gorutine start %d", i)
-		go func(txChan []*BridgeTransactionAndHash, txLock *sync.Mutex, sender *EthSender) {
+		go func(txChan []*BridgeTransactionAndHash, sender *EthSender) {
 			defer wg.Done()
 
 			for _, maxFeeOfTransactionAndHash := range txChan {
-				
 				maxFeeOfTransaction := maxFeeOfTransactionAndHash.BridgeTransaction
 				maxFeeOfTxHash := maxFeeOfTransactionAndHash.Hash
 
-				// sender := <- txSenChan
-
-				// sender := this.selectSender()
 				log.Infof("sender %s is handling poly tx (hash: %s), height: %d", sender.acc.Address.String(), hex.EncodeToString(tools.HexReverse(maxFeeOfTransaction.param.TxHash)), maxFeeOfTransaction.header.Height)
 				res := sender.commitDepositEventsWithHeader(maxFeeOfTransaction.header,
 					maxFeeOfTransaction.param,
@@ -695,35 +630,130 @@ func (this *PolyManager) handleLockDepositEvents() error {
 
 				log.Infof("sender %s tx return tx (poly hash: %s)", sender.acc.Address.String(), hex.EncodeToString(tools.HexReverse(maxFeeOfTransaction.param.TxHash)))
 
-				
 				if res {
-					this.db.DeleteBridgeTransactions(maxFeeOfTxHash)
+					if err := this.queue.Done(maxFeeOfTxHash); err != nil {
+						log.Errorf("handleLockDepositEvents - remove %s from queue error: %s", maxFeeOfTxHash, err)
+					}
 				} else {
-					log.Infof("sender %s txLock start  tx (poly hash: %s)", sender.acc.Address.String(), hex.EncodeToString(tools.HexReverse(maxFeeOfTransaction.param.TxHash)))
-					txLock.Lock()
-					retryBridgeTransactions[maxFeeOfTxHash] = maxFeeOfTransaction
-					log.Infof("sender %s txLock  Unlock start tx (poly hash: %s)", sender.acc.Address.String(), hex.EncodeToString(tools.HexReverse(maxFeeOfTransaction.param.TxHash)))
-					txLock.Unlock()
-					log.Infof("sender %s txLock  Unlock finished tx (poly hash: %s)", sender.acc.Address.String(), hex.EncodeToString(tools.HexReverse(maxFeeOfTransaction.param.TxHash)))
-
+					this.queue.Retry(maxFeeOfTxHash)
 				}
-				// txSenChan <- sender
 			}
 			log.Infof("sender %s is done", sender.acc.Address.String())
-		}(txSend[i], this.txLock, this.senders[i])
+		}(txSend[i], this.senders[i])
 	}
 
 	log.Infof("wg.Wait start")
 	wg.Wait()
 	log.Infof("wg.Wait finished")
+	return nil
+}
 
+// checkQueuedFees asks the bridge whether the fee of the queued transfers not
+// checked yet is paid, and lets the fee policy decide about them
+func (this *PolyManager) checkQueuedFees() {
+	// every transfer is sent anyway
+	if this.nofeemode && len(this.config.BridgeUrl) == 0 {
+		return
+	}
+	unchecked := this.queue.Unchecked()
+	if len(unchecked) == 0 {
+		return
+	}
+	txs := make(map[string]*BridgeTransaction, len(unchecked))
+	noCheckFees := make([]*FeeCheckReq, 0, len(unchecked))
+	for _, v := range unchecked {
+		key := bridgeTransactionKey(v)
+		if free, reason := this.feePolicy.Free(v); free && !this.nofeemode {
+			log.Infof("tx(%d,%s) is relayed free: %s", v.param.FromChainID, hex.EncodeToString(v.param.MakeTxParam.TxHash), reason)
+			if err := this.queue.SetFee(key, FEE_FREE, "", reason); err != nil {
+				log.Errorf("handleLockDepositEvents - update fee of %s error: %s", key, err)
+			}
+			continue
+		}
+		txs[key] = v
+		noCheckFees = append(noCheckFees, &FeeCheckReq{
+			ChainId: v.param.FromChainID,
+			Hash:    hex.EncodeToString(v.param.MakeTxParam.TxHash),
+		})
+	}
+	if len(noCheckFees) == 0 {
+		return
+	}
 
-	for k, v := range retryBridgeTransactions {
-		sink := common.NewZeroCopySink(nil)
-		v.Serialization(sink)
-		this.db.PutBridgeTransactions(k, sink.Bytes())
+	checkFees, err := this.feeChecker.CheckFee(noCheckFees)
+	if err != nil {
+		metrics.Alert(ALERT_FEE_CHECK_FAILED, "fee check of %d transfers failed: %s", len(noCheckFees), err)
+	} else {
+		metrics.Resolve(ALERT_FEE_CHECK_FAILED)
+	}
+	for _, checkFee := range checkFees {
+		key := fmt.Sprintf("%d%s", checkFee.ChainId, checkFee.Hash)
+		tx, ok := txs[key]
+		if !ok {
+			continue
+		}
+		var (
+			decision FeeDecision
+			reason   string
+			fee      string
+		)
+		if checkFee.PayState == FEE_HASPAY {
+			log.Infof("tx(%d,%s) has payed fee", checkFee.ChainId, checkFee.Hash)
+			fee = checkFee.Amount
+			if this.nofeemode {
+				// every transfer is relayed, the fee only orders the queue
+				decision = FEE_RELAY
+			} else {
+				decision, reason = this.feePolicy.Paid(tx, fee, func() (*big.Int, error) {
+					return this.senders[0].estimateDepositCost(tx)
+				})
+			}
+		} else if checkFee.PayState == FEE_NOTPAY {
+			log.Infof("tx(%d,%s) has not payed fee", checkFee.ChainId, checkFee.Hash)
+			if this.nofeemode {
+				if err := this.queue.SetFee(key, FEE_NOTPAY, "", ""); err != nil {
+					log.Errorf("handleLockDepositEvents - update fee of %s error: %s", key, err)
+				}
+				continue
+			}
+			decision, reason = this.feePolicy.Unpaid(tx)
+		} else {
+			log.Warnf("check fee of tx(%d,%s) failed", checkFee.ChainId, checkFee.Hash)
+			continue
+		}
+		this.applyFeeDecision(key, tx, decision, fee, reason)
+	}
+}
+
+func (this *PolyManager) applyFeeDecision(key string, tx *BridgeTransaction, decision FeeDecision, fee string, reason string) {
+	now := time.Now()
+	if decision == FEE_DEFER && this.feePolicy.DeferExpired(tx, now) {
+		decision, reason = FEE_REJECT, fmt.Sprintf("deferred for too long, %s", reason)
+	}
+	var err error
+	switch decision {
+	case FEE_RELAY:
+		if reason != "" {
+			log.Warnf("tx(%d,%s) is relayed: %s", tx.param.FromChainID, hex.EncodeToString(tx.param.MakeTxParam.TxHash), reason)
+		}
+		metrics.Add(METRIC_FEE_RELAYED, 1)
+		err = this.queue.SetFee(key, FEE_HASPAY, fee, reason)
+	case FEE_DEFER:
+		log.Infof("tx(%d,%s) is deferred: %s", tx.param.FromChainID, hex.EncodeToString(tx.param.MakeTxParam.TxHash), reason)
+		metrics.Add(METRIC_FEE_DEFERRED, 1)
+		err = this.queue.Defer(key, fee, reason, now.Add(this.feePolicy.DeferInterval()))
+	case FEE_REJECT:
+		log.Warnf("tx(%d,%s) is rejected: %s", tx.param.FromChainID, hex.EncodeToString(tx.param.MakeTxParam.TxHash), reason)
+		metrics.Add(METRIC_FEE_REJECTED, 1)
+		err = this.queue.Reject(key, fee, reason)
+	}
+	if err != nil {
+		log.Errorf("handleLockDepositEvents - %s %s error: %s", decision, key, err)
 	}
-	return nil
+}
+
+func (this *PolyManager) Senders() []*EthSender {
+	return this.senders
 }
 
 func (this *PolyManager) Stop() {
@@ -732,13 +762,9 @@ func (this *PolyManager) Stop() {
 	log.Infof("poly chain manager exit.")
 }
 
-func (this *PolyManager) checkFee(checks []*poly_bridge_sdk.CheckFeeReq) ([]*poly_bridge_sdk.CheckFeeRsp, error) {
-	return this.bridgeSdk.CheckFee(checks)
-}
-
 type EthSender struct {
 	acc          accounts.Account
-	keyStore     *tools.EthKeyStore
+	signer       tools.EthSigner
 	cmap         map[string]chan *EthTxInfo
 	result       chan bool
 	locked       bool
@@ -757,7 +783,7 @@ func (this *EthSender) sendTxToEth(info *EthTxInfo) error {
 	maxPrice := big.NewInt(0).Quo(big.NewInt(0).Mul(origin, big.NewInt(100)), big.NewInt(10))
 RETRY:
 	tx := types.NewTransaction(nonce, info.contractAddr, big.NewInt(0), info.gasLimit, info.gasPrice, info.txData)
-	signedtx, err := this.keyStore.SignTransaction(tx, this.acc)
+	signedtx, err := this.signer.SignTransaction(tx, this.acc)
 	if err != nil {
 		this.nonceManager.ReturnNonce(this.acc.Address, nonce)
 		return fmt.Errorf("commitDepositEventsWithHeader - sign raw tx error and return nonce %d: %v", nonce, err)
@@ -777,10 +803,10 @@ RETRY:
 	err2 := this.waitTransactionConfirm(info.polyTxHash, hash)
 	if err2 == nil {
 		log.Infof("successful to relay tx to ethereum: (eth_hash: %s, nonce: %d, poly_hash: %s, eth_explorer: %s)",
-			hash.String(), nonce, tools.HexStringReverse(info.polyTxHash), tools.GetExplorerUrl(this.keyStore.GetChainId())+hash.String())
+			hash.String(), nonce, tools.HexStringReverse(info.polyTxHash), tools.GetExplorerUrl(this.signer.GetChainId())+hash.String())
 	} else {
 		log.Errorf("failed to relay tx to ethereum: (eth_hash: %s, nonce: %d, poly_hash: %s, eth_explorer: %s), err: %w",
-			hash.String(), nonce, tools.HexStringReverse(info.polyTxHash), tools.GetExplorerUrl(this.keyStore.GetChainId())+hash.String(), err2)
+			hash.String(), nonce, tools.HexStringReverse(info.polyTxHash), tools.GetExplorerUrl(this.signer.GetChainId())+hash.String(), err2)
 		if info.gasPrice.Cmp(maxPrice) > 0 {
 			log.Errorf("waitTransactionConfirm failed")
 			os.Exit(1)
@@ -802,11 +828,9 @@ RETRY:
 	return nil
 }
 
-func (this *EthSender) commitDepositEventsWithHeader(header *polytypes.Header, param *common2.ToMerkleValue, headerProof string, anchorHeader *polytypes.Header, polyTxHash string, rawAuditPath []byte) bool {
-	var (
-		sigs       []byte
-		headerData []byte
-	)
+// depositTxData packs the verifyHeaderAndExecuteTx call relaying a transfer
+func (this *EthSender) depositTxData(header *polytypes.Header, headerProof string, anchorHeader *polytypes.Header, rawAuditPath []byte) ([]byte, error) {
+	var sigs []byte
 	if anchorHeader != nil && headerProof != "" {
 		for _, sig := range anchorHeader.SigData {
 			temp := make([]byte, len(sig))
@@ -822,6 +846,36 @@ func (this *EthSender) commitDepositEventsWithHeader(header *polytypes.Header, p
 			sigs = append(sigs, newsig...)
 		}
 	}
+	rawProof, _ := hex.DecodeString(headerProof)
+	var rawAnchor []byte
+	if anchorHeader != nil {
+		rawAnchor = anchorHeader.GetMessage()
+	}
+	return this.contractAbi.Pack("verifyHeaderAndExecuteTx", rawAuditPath, header.GetMessage(), rawProof, rawAnchor, sigs)
+}
+
+// estimateDepositCost estimates the gas cost in wei of relaying tx
+func (this *EthSender) estimateDepositCost(tx *BridgeTransaction) (*big.Int, error) {
+	txData, err := this.depositTxData(tx.header, tx.headerProof, tx.anchorHeader, tx.rawAuditPath)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, err := this.ethClient.SuggestGasPrice(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	contractaddr := ethcommon.HexToAddress(this.config.ETHConfig.ECCMContractAddress)
+	gasLimit, err := this.ethClient.EstimateGas(context.Background(), ethereum.CallMsg{
+		From: this.acc.Address, To: &contractaddr, Gas: 0, GasPrice: gasPrice,
+		Value: big.NewInt(0), Data: txData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit)), nil
+}
+
+func (this *EthSender) commitDepositEventsWithHeader(header *polytypes.Header, param *common2.ToMerkleValue, headerProof string, anchorHeader *polytypes.Header, polyTxHash string, rawAuditPath []byte) bool {
 	fromTx := [32]byte{}
 	copy(fromTx[:], param.TxHash[:32])
 	res, _ := this.eccdInstance.CheckIfFromChainTxExist(nil, param.FromChainID, fromTx)
@@ -832,13 +886,7 @@ func (this *EthSender) commitDepositEventsWithHeader(header *polytypes.Header, p
 	}
 	//log.Infof("poly proof with header, height: %d, key: %s, proof: %s", header.Height-1, string(key), proof.AuditPath)
 
-	rawProof, _ := hex.DecodeString(headerProof)
-	var rawAnchor []byte
-	if anchorHeader != nil {
-		rawAnchor = anchorHeader.GetMessage()
-	}
-	headerData = header.GetMessage()
-	txData, err := this.contractAbi.Pack("verifyHeaderAndExecuteTx", rawAuditPath, headerData, rawProof, rawAnchor, sigs)
+	txData, err := this.depositTxData(header, headerProof, anchorHeader, rawAuditPath)
 	if err != nil {
 		log.Errorf("commitDepositEventsWithHeader - err:" + err.Error())
 		return false
@@ -938,7 +986,7 @@ func (this *EthSender) commitHeader(header *polytypes.Header, pubkList []byte) b
 
 	nonce := this.nonceManager.GetAddressNonce(this.acc.Address)
 	tx := types.NewTransaction(nonce, contractaddr, big.NewInt(0), gasLimit, gasPrice, txData)
-	signedtx, err := this.keyStore.SignTransaction(tx, this.acc)
+	signedtx, err := this.signer.SignTransaction(tx, this.acc)
 	if err != nil {
 		log.Errorf("commitHeader - sign raw tx error: %s", err.Error())
 		return false
@@ -953,10 +1001,10 @@ func (this *EthSender) commitHeader(header *polytypes.Header, pubkList []byte) b
 	err2 := this.waitTransactionConfirm(fmt.Sprintf("header: %d", header.Height), txhash)
 	if err2 == nil {
 		log.Infof("successful to relay poly header to ethereum: (header_hash: %s, height: %d, eth_txhash: %s, nonce: %d, eth_explorer: %s)",
-			hash.ToHexString(), header.Height, txhash.String(), nonce, tools.GetExplorerUrl(this.keyStore.GetChainId())+txhash.String())
+			hash.ToHexString(), header.Height, txhash.String(), nonce, tools.GetExplorerUrl(this.signer.GetChainId())+txhash.String())
 	} else {
 		log.Errorf("failed to relay poly header to ethereum: (header_hash: %s, height: %d, eth_txhash: %s, nonce: %d, eth_explorer: %s), err: %w",
-			hash.ToHexString(), header.Height, txhash.String(), nonce, tools.GetExplorerUrl(this.keyStore.GetChainId())+txhash.String(), err2)
+			hash.ToHexString(), header.Height, txhash.String(), nonce, tools.GetExplorerUrl(this.signer.GetChainId())+txhash.String(), err2)
 	}
 	return true
 }
@@ -0,0 +1,40 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import "testing"
+
+func TestProvenByCurrentEpoch(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		hdrHeight uint32 // header of the block, its height + 1
+		lastEpoch uint32 // epoch start height of the ECCM
+		want      bool
+	}{
+		{"block in the epoch", 1001, 500, true},
+		// the epoch change is synced before the deposit is queued, the header
+		// holding both is signed by the old bookkeepers and needs an anchor
+		{"epoch change and deposit", 1000, 1000, false},
+		{"block before the epoch", 999, 1000, false},
+		{"block long before the epoch", 2, 1000, false},
+	} {
+		if got := provenByCurrentEpoch(c.hdrHeight, c.lastEpoch); got != c.want {
+			t.Errorf("%s: provenByCurrentEpoch(%d, %d) = %v, want %v", c.name, c.hdrHeight, c.lastEpoch, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/polynetwork/polygon-relayer/cosmos-relayer/service"
+	hmTypes "github.com/polynetwork/polygon-relayer/heimdall/types"
+	"github.com/polynetwork/polygon-relayer/log"
+)
+
+// SpanGate tells header sync how far it may go. A bor sprint-end header can
+// only be proven with a span that heimdall knows at the heimdall height synced
+// to poly, so header sync must not pass the end of the latest such span. The
+// gate also fetches that span and its proof ahead of time, and watches the
+// span heimdall will propose next.
+type SpanGate struct {
+	tclient *TendermintClient
+
+	mu         sync.RWMutex
+	heimHeight int64         // best heimdall height for bor
+	span       *hmTypes.Span // latest span at heimHeight - 1
+	nextSpan   *hmTypes.Span // span heimdall proposes next, may be nil
+
+	exitChan chan int
+}
+
+func NewSpanGate(tclient *TendermintClient) *SpanGate {
+	return &SpanGate{
+		tclient:  tclient,
+		exitChan: make(chan int),
+	}
+}
+
+func (this *SpanGate) Monitor(seconds uint64) {
+	log.LogSpanL.Infof("SpanGate.Monitor - start, Duration %d", seconds)
+	if err := this.refresh(); err != nil {
+		log.LogSpanL.Errorf("SpanGate.Monitor - refresh error: %s", err)
+	}
+
+	ticker := time.NewTicker(time.Duration(seconds) * time.Second)
+	for {
+		select {
+		case <-ticker.C:
+			if err := this.refresh(); err != nil {
+				log.LogSpanL.Errorf("SpanGate.Monitor - refresh error: %s", err)
+			}
+		case <-this.exitChan:
+			return
+		}
+	}
+}
+
+func (this *SpanGate) refresh() error {
+	heimHeight, err := service.GetBestCosmosHeightForBor()
+	if err != nil {
+		return fmt.Errorf("GetBestCosmosHeightForBor error: %w", err)
+	}
+	this.mu.RLock()
+	unchanged := heimHeight == this.heimHeight && this.span != nil
+	this.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	span, err := this.tclient.GetLatestSpan(heimHeight - 1)
+	if err != nil {
+		return fmt.Errorf("GetLatestSpan at %d error: %w", heimHeight-1, err)
+	}
+	// index the span and fetch its proof before header sync needs them
+	if _, err = this.tclient.putSpan(span); err != nil {
+		log.LogSpanL.Errorf("SpanGate.refresh - putSpan %d error: %s", span.ID, err)
+	}
+	if _, err = this.tclient.GetSpanResCached(span.ID, heimHeight-1); err != nil {
+		log.LogSpanL.Errorf("SpanGate.refresh - prefetch proof of span %d at %d error: %s", span.ID, heimHeight-1, err)
+	}
+
+	nextSpan, err := this.tclient.GetNextSpan(span.ID+1, 0)
+	if err != nil {
+		log.LogSpanL.Debugf("SpanGate.refresh - GetNextSpan %d error: %s", span.ID+1, err)
+		nextSpan = nil
+	}
+
+	this.mu.Lock()
+	this.heimHeight, this.span, this.nextSpan = heimHeight, span, nextSpan
+	this.mu.Unlock()
+
+	if nextSpan != nil {
+		log.LogSpanL.Infof("SpanGate.refresh - heimdall height %d, span %d (%d-%d), next span %d from bor %d",
+			heimHeight, span.ID, span.StartBlock, span.EndBlock, nextSpan.ID, nextSpan.StartBlock)
+	} else {
+		log.LogSpanL.Infof("SpanGate.refresh - heimdall height %d, span %d (%d-%d)",
+			heimHeight, span.ID, span.StartBlock, span.EndBlock)
+	}
+	return nil
+}
+
+// ReadyHeight returns the highest bor height header sync may advance to, it
+// returns false until the gate has been refreshed once.
+func (this *SpanGate) ReadyHeight() (uint64, bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	if this.span == nil {
+		return 0, false
+	}
+	// the sprint-end header at h is proven with the span of h + 1
+	return this.span.EndBlock - 1, true
+}
+
+func (this *SpanGate) Stop() {
+	close(this.exitChan)
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/polynetwork/polygon-relayer/db"
+	"github.com/polynetwork/polygon-relayer/log"
+)
+
+// SpanIndex maps bor block ranges to span ids. It is loaded from db.BKTSpan
+// once and then kept up to date by the span monitor routines, so a lookup is a
+// binary search instead of a scan of the bucket.
+type SpanIndex struct {
+	mu    sync.RWMutex
+	spans []*SpanStartEnd // sorted by StartEnd.Start
+	ids   map[uint64]*SpanStartEnd
+}
+
+func NewSpanIndex() *SpanIndex {
+	return &SpanIndex{
+		spans: make([]*SpanStartEnd, 0),
+		ids:   make(map[uint64]*SpanStartEnd),
+	}
+}
+
+// Load adds every span stored in db.BKTSpan
+func (this *SpanIndex) Load(boltDB *db.BoltDB) error {
+	all, err := boltDB.GetAllUint64(db.BKTSpan)
+	if err != nil {
+		return err
+	}
+	for _, v := range all {
+		startEnd := &StartEnd{}
+		if err := json.Unmarshal(v.V, startEnd); err != nil {
+			log.LogSpanL.Errorf("SpanIndex.Load - unmarshal span %d error: %s", v.K, err)
+			continue
+		}
+		this.Put(v.K, startEnd)
+	}
+	return nil
+}
+
+// Put adds or updates a span, it returns false if the span is already indexed
+// with the same range
+func (this *SpanIndex) Put(id uint64, startEnd *StartEnd) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if old, ok := this.ids[id]; ok {
+		if *old.StartEnd == *startEnd {
+			return false
+		}
+		i := this.search(old.StartEnd.Start)
+		for ; i < len(this.spans) && this.spans[i] != old; i++ {
+		}
+		this.spans = append(this.spans[:i], this.spans[i+1:]...)
+	}
+
+	se := &SpanStartEnd{ID: id, StartEnd: &StartEnd{Start: startEnd.Start, End: startEnd.End}}
+	i := this.search(startEnd.Start)
+	this.spans = append(this.spans, nil)
+	copy(this.spans[i+1:], this.spans[i:])
+	this.spans[i] = se
+	this.ids[id] = se
+	return true
+}
+
+// search returns the index of the first span starting at or after start
+func (this *SpanIndex) search(start uint64) int {
+	return sort.Search(len(this.spans), func(i int) bool {
+		return this.spans[i].StartEnd.Start >= start
+	})
+}
+
+// Find returns the id of the span containing bor block height
+func (this *SpanIndex) Find(height uint64) (uint64, bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	// last span starting at or before height
+	i := sort.Search(len(this.spans), func(i int) bool {
+		return this.spans[i].StartEnd.Start > height
+	}) - 1
+	if i < 0 || height > this.spans[i].StartEnd.End {
+		return 0, false
+	}
+	return this.spans[i].ID, true
+}
+
+// Get returns the range of span id
+func (this *SpanIndex) Get(id uint64) (*StartEnd, bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	se, ok := this.ids[id]
+	if !ok {
+		return nil, false
+	}
+	return &StartEnd{Start: se.StartEnd.Start, End: se.StartEnd.End}, true
+}
+
+func (this *SpanIndex) Has(id uint64) bool {
+	_, ok := this.Get(id)
+	return ok
+}
+
+// Bounds returns the first and the last indexed span, nil if the index is empty
+func (this *SpanIndex) Bounds() (*SpanStartEnd, *SpanStartEnd) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	if len(this.spans) == 0 {
+		return nil, nil
+	}
+	return this.spans[0], this.spans[len(this.spans)-1]
+}
+
+// MaxId returns the highest indexed span id
+func (this *SpanIndex) MaxId() (uint64, bool) {
+	_, last := this.Bounds()
+	if last == nil {
+		return 0, false
+	}
+	return last.ID, true
+}
+
+func (this *SpanIndex) Len() int {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	return len(this.spans)
+}
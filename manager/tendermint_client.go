@@ -21,8 +21,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/polynetwork/polygon-relayer/cosmos-sdk/codec"
@@ -30,9 +29,8 @@ import (
 	"github.com/polynetwork/polygon-relayer/types"
 
 	abcitypes "github.com/christianxiao/tendermint/abci/types"
-	rpcclient "github.com/christianxiao/tendermint/rpc/client"
-	tdmt_types "github.com/christianxiao/tendermint/types"
 
+	"github.com/polynetwork/polygon-relayer/heimdall"
 	hmTypes "github.com/polynetwork/polygon-relayer/heimdall/types"
 	"github.com/polynetwork/polygon-relayer/log"
 
@@ -40,27 +38,29 @@ import (
 )
 
 type TendermintClient struct {
-	RPCHttp *rpcclient.HTTP
-	Codec   *codec.Codec
+	Heimdall *heimdall.Client
+	Codec    *codec.Codec
 
 	db       *db.BoltDB
+	spans    *SpanIndex
 	exitChan chan int
-}
-
-var SpanPrefixKey = []byte{0x36} // prefix key to store span
 
-// GetSpanKey appends prefix to start block
-func GetSpanKey(id uint64) []byte {
-	return append(SpanPrefixKey, []byte(strconv.FormatUint(id, 10))...)
+	latestMu sync.Mutex
+	latest   *hmTypes.Span // latest span, refreshed by MonitorSpanLatestRoutine
 }
 
-func NewTendermintClient(addr string, db *db.BoltDB, cdc *codec.Codec, tclient *rpcclient.HTTP) (*TendermintClient, error) {
-	c := tclient
+func NewTendermintClient(db *db.BoltDB, hclient *heimdall.Client) (*TendermintClient, error) {
+	spans := NewSpanIndex()
+	if err := spans.Load(db); err != nil {
+		return nil, fmt.Errorf("NewTendermintClient - load span index error: %w", err)
+	}
+	log.LogSpanL.Infof("NewTendermintClient - %d spans loaded", spans.Len())
 
 	return &TendermintClient{
-		RPCHttp:  c,
-		Codec:    cdc,
+		Heimdall: hclient,
+		Codec:    hclient.Codec(),
 		db:       db,
+		spans:    spans,
 		exitChan: make(chan int),
 	}, nil
 }
@@ -76,27 +76,134 @@ type SpanStartEnd struct {
 }
 
 func (this *TendermintClient) GetSpanIdByBor(bor uint64) (uint64, error) {
-	all, err := this.db.GetAllUint64(db.BKTSpan)
-	if err != nil {
-		return 0, err
+	if id, ok := this.spans.Find(bor + 1); ok {
+		return id, nil
 	}
 
-	allStrt := make([]*SpanStartEnd, len(all))
-	for _, v := range all {
-		var startEnd = &StartEnd{}
-		json.Unmarshal(v.V, startEnd)
+	first, last := this.spans.Bounds()
+	indexed := "none"
+	if first != nil {
+		indexed = fmt.Sprintf("span %d (%d-%d) to span %d (%d-%d)", first.ID, first.StartEnd.Start, first.StartEnd.End,
+			last.ID, last.StartEnd.Start, last.StartEnd.End)
+	}
+	log.LogSpanL.Warnf("DB GetSpanIdByBor: span not found! bor height: %d, indexed: %s", bor, indexed)
+
+	return 0, fmt.Errorf("DB GetSpanIdByBor: span not found! bor height: %d, indexed: %s, error: %w", bor, indexed, mytypes.ErrSpanNotFound)
+}
+
+// putSpan stores the span and adds its range to the span index. It returns
+// false if the span is already stored unchanged.
+func (this *TendermintClient) putSpan(span *hmTypes.Span) (bool, error) {
+	data, err := json.Marshal(span)
+	if err != nil {
+		return false, err
+	}
+	startEnd := &StartEnd{Start: span.StartBlock, End: span.EndBlock}
+	old, _ := this.db.GetUint64(db.BKTSpanData, span.ID)
+	if bytes.Equal(old, data) {
+		if se, ok := this.spans.Get(span.ID); ok && *se == *startEnd {
+			return false, nil
+		}
+	}
 
-		allStrt = append(allStrt, &SpanStartEnd{v.K, startEnd})
+	if err = this.db.PutUint64(db.BKTSpanData, span.ID, data); err != nil {
+		return false, err
+	}
+	vjson, err := json.Marshal(startEnd)
+	if err != nil {
+		return false, err
+	}
+	if err = this.db.PutUint64(db.BKTSpan, span.ID, vjson); err != nil {
+		return false, err
+	}
+	this.spans.Put(span.ID, startEnd)
+	return true, nil
+}
 
-		if startEnd.Start <= (bor+1) && (bor+1) <= startEnd.End {
-			return v.K, nil
+// GetSpan returns the full span, from the db if stored and final, otherwise
+// the latest span kept in memory or from heimdall. Heimdall may replace the
+// latest span until it starts, so a span is only final once a later one is
+// known.
+func (this *TendermintClient) GetSpan(id uint64) (*hmTypes.Span, error) {
+	if maxId, ok := this.spans.MaxId(); !ok || id >= maxId {
+		if span := this.latestSpan(); span != nil && span.ID == id {
+			return span, nil
+		}
+		span, err := this.fetchSpan(id)
+		if err != nil {
+			return nil, err
+		}
+		this.setLatestSpan(span)
+		return span, nil
+	}
+	if data, _ := this.db.GetUint64(db.BKTSpanData, id); len(data) != 0 {
+		span := new(hmTypes.Span)
+		if err := json.Unmarshal(data, span); err == nil {
+			return span, nil
 		}
+		log.LogSpanL.Errorf("GetSpan - unmarshal stored span %d failed, fetch it again", id)
 	}
+	return this.fetchSpan(id)
+}
 
-	allStrtBytes, _ := json.MarshalIndent(allStrt, "", "    ")
-	log.LogSpanL.Warnf("DB GetSpanIdByBor: span not found! bor height: %d, db data: %s", bor, string(allStrtBytes))
+func (this *TendermintClient) latestSpan() *hmTypes.Span {
+	this.latestMu.Lock()
+	defer this.latestMu.Unlock()
 
-	return 0, fmt.Errorf("DB GetSpanIdByBor: span not found! bor height: %d, db data: %s, error: %w", bor, string(allStrtBytes), mytypes.ErrSpanNotFound)
+	return this.latest
+}
+
+// setLatestSpan keeps span in memory if it is not older than the one kept
+func (this *TendermintClient) setLatestSpan(span *hmTypes.Span) {
+	this.latestMu.Lock()
+	defer this.latestMu.Unlock()
+
+	if this.latest == nil || span.ID >= this.latest.ID {
+		this.latest = span
+	}
+}
+
+func (this *TendermintClient) fetchSpan(id uint64) (*hmTypes.Span, error) {
+	_, span, err := this.GetSpanRes(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = this.putSpan(span); err != nil {
+		log.LogSpanL.Errorf("fetchSpan - putSpan %d error: %s", id, err)
+	}
+	return span, nil
+}
+
+// GetSpanResCached is GetSpanRes served from the db once the response at
+// heimHeight has been fetched. heimHeight 0 means latest and is not cached.
+func (this *TendermintClient) GetSpanResCached(id uint64, heimHeight int64) (*abcitypes.ResponseQuery, error) {
+	if heimHeight > 0 {
+		if raw := this.db.GetSpanProof(uint64(heimHeight), id); len(raw) != 0 {
+			res := new(abcitypes.ResponseQuery)
+			if err := res.Unmarshal(raw); err == nil {
+				return res, nil
+			}
+			log.LogSpanL.Errorf("GetSpanResCached - unmarshal stored response of span %d at %d failed, fetch it again", id, heimHeight)
+		}
+	}
+
+	res, span, err := this.GetSpanRes(id, heimHeight)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = this.putSpan(span); err != nil {
+		log.LogSpanL.Errorf("GetSpanResCached - putSpan %d error: %s", id, err)
+	}
+	if heimHeight > 0 {
+		raw, err := res.Marshal()
+		if err == nil {
+			err = this.db.PutSpanProof(uint64(heimHeight), id, raw)
+		}
+		if err != nil {
+			log.LogSpanL.Errorf("GetSpanResCached - store response of span %d at %d error: %s", id, heimHeight, err)
+		}
+	}
+	return res, nil
 }
 
 func (this *TendermintClient) MonitorSpanLatestRoutine(seconds uint64) {
@@ -113,41 +220,22 @@ func (this *TendermintClient) MonitorSpanLatestRoutine(seconds uint64) {
 			}
 			span, err := this.GetLatestSpan(h)
 			if err != nil {
-				log.LogSpanL.Errorf("MonitorSpanLatestRoutine - cannot get span from node height: %s err: %s", h, err.Error())
+				log.LogSpanL.Errorf("MonitorSpanLatestRoutine - cannot get span from node height: %d err: %s", h, err.Error())
 				continue
 			}
 
-			//check db
-			val, err := this.db.GetUint64(db.BKTSpan, span.ID)
-			if err != nil {
-				log.LogSpanL.Errorf("MonitorSpanLatestRoutine - db.GetSpan error, spanId %s  error: %s", span.ID, err.Error())
-			}
-			varStrt := &StartEnd{}
-			json.Unmarshal(val, varStrt)
-			log.LogSpanL.Infof("MonitorSpanLatestRoutine - GetLatestHeight %d, lastest span: %d (%d-%d), db exist: %t",
-				h, span.ID, span.StartBlock, span.EndBlock, len(val) != 0)
-
-			var se = &StartEnd{
-				Start: span.StartBlock,
-				End:   span.EndBlock,
-			}
+			log.LogSpanL.Infof("MonitorSpanLatestRoutine - GetLatestHeight %d, lastest span: %d (%d-%d), indexed: %t",
+				h, span.ID, span.StartBlock, span.EndBlock, this.spans.Has(span.ID))
 
-			vjson, err := json.Marshal(se)
+			this.setLatestSpan(span)
+			updated, err := this.putSpan(span)
 			if err != nil {
-				log.LogSpanL.Errorf("MonitorSpanLatestRoutine - Marshal, err: %s", err.Error())
-				continue
-			}
-
-			if bytes.Equal(vjson, val) {
+				log.LogSpanL.Errorf("MonitorSpanLatestRoutine - putSpan err: %v", err)
 				continue
 			}
-
-			err2 := this.db.PutUint64(db.BKTSpan, span.ID, vjson)
-			if err2 != nil {
-				log.LogSpanL.Errorf("MonitorSpanLatestRoutine - db.PutUint64 err: %v", err2)
-				continue
+			if updated {
+				log.LogSpanL.Infof("MonitorSpanLatestRoutine - putSpan, span.id: %d (%d-%d)", span.ID, span.StartBlock, span.EndBlock)
 			}
-			log.LogSpanL.Infof("MonitorSpanLatestRoutine - db.PutUint64, span.id: %s, data: %s", span.ID, string(vjson))
 
 		case <-this.exitChan:
 			return
@@ -159,51 +247,34 @@ func (this *TendermintClient) MonitorSpanHisRoutine(start uint64) {
 	log.LogSpanH.Infof("tendermint_client.MonitorSpanHisRoutine - start, start %d", start)
 
 	for true {
-		all, err := this.db.GetAllUint64(db.BKTSpan)
-		if err != nil {
-			log.LogSpanH.Errorf("MonitorSpanHisRoutine - error, err: %s", err.Error())
-			time.Sleep(60 * time.Second)
+		max, ok := this.spans.MaxId()
+		if !ok {
+			time.Sleep(10 * time.Second)
 			continue
 		}
-		log.LogSpanH.Debugf("MonitorSpanHisRoutine - db.GetAllSpan, data: %s", all)
-
-		allmap := make(map[uint64][]byte)
-		for _, v := range all {
-			allmap[v.K] = v.V
-		}
 
-		if len(all) == 0 {
-			continue
-		}
-
-		max := all[0].K
-		for i := max; i >= start; i-- {
+		for next := max + 1; next > start; next-- {
+			i := next - 1
 			// lastest pan may change, need to update everytime
-			_, ok := allmap[i]
-			if i == max || !ok {
-				_, span, err := this.GetSpanRes(i, 0)
-				if err != nil {
-					log.LogSpanH.Errorf("MonitorSpanHisRoutine - GetSpanRes error, id %d, err: %s", i, err.Error())
-					time.Sleep(10 * time.Second)
+			if i != max && this.spans.Has(i) {
+				if data, _ := this.db.GetUint64(db.BKTSpanData, i); len(data) != 0 {
 					continue
 				}
+			}
+			_, span, err := this.GetSpanRes(i, 0)
+			if err != nil {
+				log.LogSpanH.Errorf("MonitorSpanHisRoutine - GetSpanRes error, id %d, err: %s", i, err.Error())
+				time.Sleep(10 * time.Second)
+				continue
+			}
 
-				var se = &StartEnd{
-					Start: span.StartBlock,
-					End:   span.EndBlock,
-				}
-
-				vjson, err := json.Marshal(se)
-				if err != nil {
-					log.LogSpanH.Errorf("MonitorSpanHisRoutine - Marshal, err: %s", err.Error())
-					continue
-				}
-				err2 := this.db.PutUint64(db.BKTSpan, span.ID, vjson)
-				if err2 != nil {
-					log.LogSpanH.Errorf("MonitorSpanHisRoutine - db.PutSpan err: %s", err2.Error())
-					continue
-				}
-				log.LogSpanH.Infof("MonitorSpanHisRoutine - db.PutSpan, span.id: %d, data: %s", span.ID, string(vjson))
+			updated, err := this.putSpan(span)
+			if err != nil {
+				log.LogSpanH.Errorf("MonitorSpanHisRoutine - putSpan err: %s", err.Error())
+				continue
+			}
+			if updated {
+				log.LogSpanH.Infof("MonitorSpanHisRoutine - putSpan, span.id: %d (%d-%d)", span.ID, span.StartBlock, span.EndBlock)
 			}
 		}
 
@@ -212,108 +283,23 @@ func (this *TendermintClient) MonitorSpanHisRoutine(start uint64) {
 }
 
 func (this *TendermintClient) GetLatestHeight() (int64, error) {
-	statusRes, err := this.RPCHttp.Status()
-	if err != nil {
-		return 0, err
-	}
-	return statusRes.SyncInfo.LatestBlockHeight, nil
+	return this.Heimdall.LatestHeight()
 }
 
 func (this *TendermintClient) GetLatestSpan(block int64) (*hmTypes.Span, error) {
-	res, err := this.RPCHttp.ABCIQueryWithOptions(
-		"custom/bor/latest-span",
-		nil,
-		rpcclient.ABCIQueryOptions{Prove: true, Height: block})
-	if err != nil {
-		log.Errorf("tendermint_client.GetSpanRes - failed, block %d, %v", block, err)
-		return nil, err
-	}
-
-	var span = new(hmTypes.Span)
-	err2 := json.Unmarshal(res.Response.Value, &span)
-	if err2 != nil {
-		log.Errorf("tendermint_client.GetLatestSpan - unmarshal failed, block %d, %v", block, err2)
-		return nil, err2
-	}
+	return this.Heimdall.LatestSpan(block)
+}
 
-	return span, nil
+// GetNextSpan returns the span heimdall would propose next as span id, at heimdall height block
+func (this *TendermintClient) GetNextSpan(id uint64, block int64) (*hmTypes.Span, error) {
+	return this.Heimdall.NextSpan(id, block)
 }
 
 // block: 0 = latest
 func (this *TendermintClient) GetSpanRes(id uint64, heimHeight int64) (*abcitypes.ResponseQuery, *hmTypes.Span, error) {
-	res, err := this.RPCHttp.ABCIQueryWithOptions(
-		"/store/bor/key",
-		GetSpanKey(id),
-		rpcclient.ABCIQueryOptions{Prove: true, Height: heimHeight})
-	if err != nil {
-		return nil, nil, fmt.Errorf("tendermint_client.GetSpanRes - failed, spanID %d, heimHeight %d, %w", id, heimHeight, err)
-	}
-
-	if len(res.Response.Value) == 0 {
-		// The spanID is too new in old heimHeight
-		return nil, nil, fmt.Errorf("tendermint_client.GetSpanRes - failed, the spanID is too new in old heimHeight, res.Response.Value is nil, spanID %d, heimHeight %d, error: %w",
-			id, heimHeight, types.ErrSpanNotFound)
-	}
-
-	if len(res.Response.Proof.GetOps()) == 0 {
-		// The spanID is too new in old heimHeight
-		return nil, nil, fmt.Errorf("tendermint_client.GetSpanRes - failed, the spanID is too new in old heimHeight, res.Response.Proof is nil, spanID %d, heimHeight %d, error: %w",
-			id, heimHeight, types.ErrSpanNotFound)
-	}
-
-	if len(res.Response.Key) == 0 {
-		// The spanID is too new in old heimHeight
-		return nil, nil, fmt.Errorf("tendermint_client.GetSpanRes - failed, the spanID is too new in old heimHeight, res.Response.Key is nil, spanID %d, heimHeight %d, error: %w",
-			id, heimHeight, types.ErrSpanNotFound)
-	}
-
-	var span = new(hmTypes.Span)
-	err2 := this.Codec.UnmarshalBinaryBare(res.Response.Value[:], span)
-	if err2 != nil {
-		return nil, nil, fmt.Errorf("tendermint_client.GetSpan - unmarshal failed, id %d, heimHeight %d, %w", id, heimHeight, err)
-	}
-
-	return &res.Response, span, nil
+	return this.Heimdall.SpanWithProof(id, heimHeight)
 }
 
 func (this *TendermintClient) GetCosmosHdr(h int64) (*types.CosmosHeader, error) {
-	log.Debugf("bor time analyse - this.RPCHttp.Commit start, bor height: %d", h)
-	rc, err := this.RPCHttp.Commit(&h)
-	log.Debugf("bor time analyse - this.RPCHttp.Commit   end, bor height: %d", h)
-	if err != nil {
-		return nil, fmt.Errorf("tendermint_client.GetCosmosHdr - commit error, to get Commit of height %d: %v", h, err)
-	}
-
-	log.Debugf("bor time analyse - this.getValidators start, bor height: %d", h)
-	vSet, err := this.getValidators(h)
-	log.Debugf("bor time analyse - this.getValidators   end, bor height: %d", h)
-	
-	if err != nil {
-		return nil, fmt.Errorf("failed to get Validators of height %d: %v", h, err)
-	}
-	return &types.CosmosHeader{
-		Header:  *rc.Header,
-		Commit:  rc.Commit,
-		Valsets: vSet,
-	}, nil
-}
-
-// TODO: this only return first 100 items
-func (this *TendermintClient) getValidators(h int64) ([]*tdmt_types.Validator, error) {
-	vSet := make([]*tdmt_types.Validator, 0)
-
-	res, err := this.RPCHttp.Validators(&h)
-	if err != nil {
-		if strings.Contains(err.Error(), "page should be within") {
-			return vSet, nil
-		}
-		return nil, err
-	}
-	// In case tendermint don't give relayer the right error
-	if len(res.Validators) == 0 {
-		return vSet, nil
-	}
-	vSet = append(vSet, res.Validators...)
-
-	return vSet, nil
+	return this.Heimdall.CosmosHeader(h)
 }
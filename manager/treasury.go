@@ -0,0 +1,305 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/polynetwork/polygon-relayer/config"
+	"github.com/polynetwork/polygon-relayer/db"
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/tools"
+)
+
+const (
+	TOPUP_GAS_LIMIT      = 21000
+	TOPUP_CHECK_INTERVAL = 60
+
+	TOPUP_SENT      = "sent"
+	TOPUP_CONFIRMED = "confirmed"
+	TOPUP_FAILED    = "failed"
+)
+
+// TopUpRecord is the audit log entry of one top-up, stored in db.BKTTopUp
+type TopUpRecord struct {
+	Time    int64
+	From    string
+	To      string
+	Amount  string
+	Balance string // balance of the sender before the top-up
+	TxHash  string
+	Status  string
+	Error   string
+}
+
+func (this *TopUpRecord) key() []byte {
+	return append(tools.Uint64ToBigEndian(uint64(this.Time)), ethcommon.HexToAddress(this.To).Bytes()...)
+}
+
+// Treasury sends MATIC from the treasury account to any relayer sender whose
+// balance drops below the configured floor.
+type Treasury struct {
+	config   *config.TreasuryConfig
+	sender   *EthSender
+	senders  []*EthSender
+	db       *db.BoltDB
+	floor    *big.Int
+	target   *big.Int
+	dailyCap *big.Int
+	exitChan chan int
+	doneChan chan int
+}
+
+func NewTreasury(servCfg *config.ServiceConfig, senders []*EthSender, ethereumsdk *ethclient.Client, boltDB *db.BoltDB) (*Treasury, error) {
+	cfg := servCfg.TreasuryConfig
+	floor, ok := new(big.Int).SetString(cfg.FloorBalance, 10)
+	if !ok {
+		return nil, fmt.Errorf("NewTreasury - invalid FloorBalance: %s", cfg.FloorBalance)
+	}
+	target, ok := new(big.Int).SetString(cfg.TargetBalance, 10)
+	if !ok {
+		return nil, fmt.Errorf("NewTreasury - invalid TargetBalance: %s", cfg.TargetBalance)
+	}
+	if target.Cmp(floor) <= 0 {
+		return nil, fmt.Errorf("NewTreasury - TargetBalance %s must be greater than FloorBalance %s", cfg.TargetBalance, cfg.FloorBalance)
+	}
+	dailyCap, ok := new(big.Int).SetString(cfg.DailyCap, 10)
+	if !ok {
+		return nil, fmt.Errorf("NewTreasury - invalid DailyCap: %s", cfg.DailyCap)
+	}
+
+	chainId, err := ethereumsdk.ChainID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	ks := tools.NewEthKeyStore(&config.ETHConfig{KeyStorePath: cfg.KeyStorePath}, chainId)
+	var acc *accounts.Account
+	for _, v := range ks.GetAccounts() {
+		if cfg.Address == "" || strings.EqualFold(v.Address.String(), cfg.Address) {
+			a := v
+			acc = &a
+			break
+		}
+	}
+	if acc == nil {
+		return nil, fmt.Errorf("NewTreasury - treasury account %s not found under %s", cfg.Address, cfg.KeyStorePath)
+	}
+	if err = ks.UnlockAccount(*acc, cfg.KeyStorePwd); err != nil {
+		return nil, err
+	}
+	for _, v := range senders {
+		if v.acc.Address == acc.Address {
+			return nil, fmt.Errorf("NewTreasury - treasury account %s is also a relayer sender", acc.Address.String())
+		}
+	}
+
+	return &Treasury{
+		config: cfg,
+		sender: &EthSender{
+			acc:          *acc,
+			signer:       ks,
+			ethClient:    ethereumsdk,
+			nonceManager: tools.NewNonceManager(ethereumsdk),
+			config:       servCfg,
+		},
+		senders:  senders,
+		db:       boltDB,
+		floor:    floor,
+		target:   target,
+		dailyCap: dailyCap,
+		exitChan: make(chan int),
+		doneChan: make(chan int),
+	}, nil
+}
+
+func (this *Treasury) MonitorBalance() {
+	defer close(this.doneChan)
+	interval := this.config.CheckInterval
+	if interval == 0 {
+		interval = TOPUP_CHECK_INTERVAL
+	}
+	log.Infof("Treasury.MonitorBalance - start, treasury: %s, floor: %s, target: %s, daily cap: %s",
+		this.sender.acc.Address.String(), this.floor.String(), this.target.String(), this.dailyCap.String())
+
+	monitorTicker := time.NewTicker(time.Duration(interval) * time.Second)
+	for {
+		select {
+		case <-monitorTicker.C:
+			if err := this.checkSenders(); err != nil {
+				log.Errorf("Treasury.MonitorBalance - checkSenders error: %v", err)
+			}
+		case <-this.exitChan:
+			return
+		}
+	}
+}
+
+// checkSenders tops up every sender below the floor, within today's caps.
+func (this *Treasury) checkSenders() error {
+	spent, counts, err := this.todayUsage()
+	if err != nil {
+		return err
+	}
+	for _, v := range this.senders {
+		bal, err := v.Balance()
+		if err != nil {
+			log.Errorf("Treasury.checkSenders - failed to get balance for %s: %v", v.acc.Address.String(), err)
+			continue
+		}
+		if bal.Cmp(this.floor) >= 0 {
+			continue
+		}
+		to := strings.ToLower(v.acc.Address.String())
+		if this.config.MaxTopUpsPerDay > 0 && counts[to] >= this.config.MaxTopUpsPerDay {
+			log.Warnf("Treasury.checkSenders - sender %s balance %s is below floor, but reached %d top-ups today",
+				v.acc.Address.String(), bal.String(), counts[to])
+			continue
+		}
+		amount := new(big.Int).Sub(this.target, bal)
+		remain := new(big.Int).Sub(this.dailyCap, spent)
+		if remain.Sign() <= 0 {
+			log.Warnf("Treasury.checkSenders - daily cap %s reached, sender %s balance %s is below floor",
+				this.dailyCap.String(), v.acc.Address.String(), bal.String())
+			return nil
+		}
+		if amount.Cmp(remain) > 0 {
+			log.Warnf("Treasury.checkSenders - top-up of %s to %s is limited to %s by daily cap",
+				amount.String(), v.acc.Address.String(), remain.String())
+			amount = remain
+		}
+
+		sent, err := this.topUp(v, bal, amount)
+		if err != nil {
+			log.Errorf("Treasury.checkSenders - top up %s error: %v", v.acc.Address.String(), err)
+		}
+		// an unconfirmed transfer may still be mined, count it against the caps
+		if sent {
+			spent.Add(spent, amount)
+			counts[to]++
+		}
+	}
+	return nil
+}
+
+// topUp returns whether the transfer has been broadcast
+func (this *Treasury) topUp(to *EthSender, balance *big.Int, amount *big.Int) (bool, error) {
+	record := &TopUpRecord{
+		Time:    time.Now().UnixNano(),
+		From:    this.sender.acc.Address.String(),
+		To:      to.acc.Address.String(),
+		Amount:  amount.String(),
+		Balance: balance.String(),
+	}
+
+	hash, err := this.sender.transfer(to.acc.Address, amount)
+	if err != nil {
+		record.Status = TOPUP_FAILED
+		record.Error = err.Error()
+		this.putRecord(record)
+		return false, err
+	}
+	record.TxHash = hash.String()
+	record.Status = TOPUP_SENT
+	this.putRecord(record)
+	log.Infof("Treasury.topUp - send %s wei to %s, balance: %s, tx: %s", amount.String(), to.acc.Address.String(), balance.String(), hash.String())
+
+	if err = this.sender.waitTransactionConfirm("", hash); err != nil {
+		record.Status = TOPUP_FAILED
+		record.Error = err.Error()
+		this.putRecord(record)
+		return true, err
+	}
+	record.Status = TOPUP_CONFIRMED
+	this.putRecord(record)
+	return true, nil
+}
+
+func (this *Treasury) putRecord(record *TopUpRecord) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Treasury.putRecord - marshal error: %v", err)
+		return
+	}
+	if err = this.db.PutTopUp(record.key(), raw); err != nil {
+		log.Errorf("Treasury.putRecord - db.PutTopUp error: %v, record: %s", err, string(raw))
+	}
+}
+
+// todayUsage returns the amount sent and the top-ups per sender since UTC midnight
+func (this *Treasury) todayUsage() (*big.Int, map[string]int, error) {
+	now := time.Now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	raws, err := this.db.GetTopUpsSince(uint64(midnight.UnixNano()))
+	if err != nil {
+		return nil, nil, err
+	}
+	spent := big.NewInt(0)
+	counts := make(map[string]int)
+	for _, raw := range raws {
+		record := &TopUpRecord{}
+		if err := json.Unmarshal(raw, record); err != nil {
+			log.Errorf("Treasury.todayUsage - unmarshal error: %v", err)
+			continue
+		}
+		if record.TxHash == "" {
+			continue
+		}
+		amount, ok := new(big.Int).SetString(record.Amount, 10)
+		if ok {
+			spent.Add(spent, amount)
+		}
+		counts[strings.ToLower(record.To)]++
+	}
+	return spent, counts, nil
+}
+
+// Stop ends a running MonitorBalance and waits for the top-up in progress, so
+// its record is stored before the relayer exits
+func (this *Treasury) Stop() {
+	close(this.exitChan)
+	<-this.doneChan
+	log.Infof("treasury exit.")
+}
+
+// transfer sends amount of the native token to addr
+func (this *EthSender) transfer(addr ethcommon.Address, amount *big.Int) (ethcommon.Hash, error) {
+	gasPrice, err := this.ethClient.SuggestGasPrice(context.Background())
+	if err != nil {
+		return ethcommon.Hash{}, fmt.Errorf("transfer - get suggest gas price failed error: %s", err.Error())
+	}
+	nonce := this.nonceManager.GetAddressNonce(this.acc.Address)
+	tx := types.NewTransaction(nonce, addr, amount, TOPUP_GAS_LIMIT, gasPrice, nil)
+	signedtx, err := this.signer.SignTransaction(tx, this.acc)
+	if err != nil {
+		this.nonceManager.ReturnNonce(this.acc.Address, nonce)
+		return ethcommon.Hash{}, fmt.Errorf("transfer - sign raw tx error and return nonce %d: %v", nonce, err)
+	}
+	if err = this.ethClient.SendTransaction(context.Background(), signedtx); err != nil {
+		this.nonceManager.ReturnNonce(this.acc.Address, nonce)
+		return ethcommon.Hash{}, fmt.Errorf("transfer - send transaction error and return nonce %d: %v", nonce, err)
+	}
+	return signedtx.Hash(), nil
+}
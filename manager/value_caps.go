@@ -0,0 +1,229 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/polynetwork/polygon-relayer/config"
+	"github.com/polynetwork/polygon-relayer/db"
+	"github.com/polynetwork/polygon-relayer/log"
+	"github.com/polynetwork/polygon-relayer/metrics"
+	mytypes "github.com/polynetwork/polygon-relayer/types"
+)
+
+const (
+	METRIC_VALUE_CAP_HELD = "value_caps.held"
+
+	ALERT_TRANSFER_HELD = "transfer_held"
+)
+
+// ValueCaps caps the value relayed through the lock proxies: a transfer over
+// the per transfer cap of its asset, or that would take the asset over its
+// cap for the window, is held for approval instead of being relayed, as is a
+// transfer whose args cannot be decoded. The windows restart with the relayer.
+type ValueCaps struct {
+	proxies map[ethcommon.Address]bool
+	caps    map[string]*valueCap // by "chainId:asset" or "*"
+
+	mu   sync.Mutex
+	sent map[string][]valueSent // transfers in the window, by "chainId:asset"
+}
+
+type valueCap struct {
+	maxPerTx     *big.Int // nil for no cap
+	maxPerWindow *big.Int // nil for no cap
+	window       time.Duration
+}
+
+type valueSent struct {
+	at     time.Time
+	amount *big.Int
+}
+
+// NewValueCaps returns caps that hold nothing if cfg is nil, cfg must be
+// validated
+func NewValueCaps(cfg *config.ValueCapsConfig) *ValueCaps {
+	this := &ValueCaps{
+		proxies: make(map[ethcommon.Address]bool),
+		caps:    make(map[string]*valueCap),
+		sent:    make(map[string][]valueSent),
+	}
+	if cfg == nil {
+		return this
+	}
+	for _, v := range cfg.LockProxies {
+		this.proxies[ethcommon.HexToAddress(v)] = true
+	}
+	for k, v := range cfg.Assets {
+		c := &valueCap{window: time.Duration(v.Window) * time.Second}
+		if v.MaxPerTx != "" {
+			c.maxPerTx, _ = new(big.Int).SetString(v.MaxPerTx, 10)
+		}
+		if v.MaxPerWindow != "" {
+			c.maxPerWindow, _ = new(big.Int).SetString(v.MaxPerWindow, 10)
+		}
+		this.caps[assetKey(k)] = c
+	}
+	return this
+}
+
+func assetKey(key string) string {
+	if key == "*" {
+		return key
+	}
+	asset := strings.SplitN(key, ":", 2)
+	return asset[0] + ":" + strings.ToLower(strings.TrimPrefix(asset[1], "0x"))
+}
+
+// ValueCapsBatch checks the transfers of one block. The transfers it lets
+// through only count against the windows once the block is stored, with
+// Commit, so a block handled again is not counted twice.
+type ValueCapsBatch struct {
+	caps    *ValueCaps
+	pending map[string][]*big.Int // amounts let through, by "chainId:asset"
+}
+
+// Batch returns a batch for the transfers of one block
+func (this *ValueCaps) Batch() *ValueCapsBatch {
+	return &ValueCapsBatch{
+		caps:    this,
+		pending: make(map[string][]*big.Int),
+	}
+}
+
+// Check returns whether a transfer of lock proxy proxy with args, whose
+// asset is on chain assetChain, is held and why. The transfers the batch let
+// through before count against the window of their asset.
+func (this *ValueCapsBatch) Check(proxy ethcommon.Address, assetChain uint64, args []byte) (bool, string) {
+	if !this.caps.proxies[proxy] {
+		return false, ""
+	}
+	unlock, err := mytypes.DecodeLockProxyArgs(args)
+	if err != nil {
+		return true, fmt.Sprintf("lock proxy %s: %s", proxy.String(), err)
+	}
+	key := fmt.Sprintf("%d:%s", assetChain, hex.EncodeToString(unlock.ToAsset))
+	c := this.caps.capOf(key)
+	if c == nil {
+		return false, ""
+	}
+	if c.maxPerTx != nil && unlock.Amount.Cmp(c.maxPerTx) > 0 {
+		return true, fmt.Sprintf("asset %s amount %s is over the per transfer cap %s", key, unlock.Amount.String(), c.maxPerTx.String())
+	}
+	if c.maxPerWindow != nil {
+		total := new(big.Int).Set(unlock.Amount)
+		total.Add(total, this.caps.inWindow(key, c.window, time.Now()))
+		for _, v := range this.pending[key] {
+			total.Add(total, v)
+		}
+		if total.Cmp(c.maxPerWindow) > 0 {
+			return true, fmt.Sprintf("asset %s amount %s takes the window total to %s, over the cap %s per %s",
+				key, unlock.Amount.String(), total.String(), c.maxPerWindow.String(), c.window)
+		}
+	}
+	this.pending[key] = append(this.pending[key], unlock.Amount)
+	return false, ""
+}
+
+// Commit counts the transfers the batch let through against the windows, once
+// their block is stored
+func (this *ValueCapsBatch) Commit() {
+	this.caps.mu.Lock()
+	defer this.caps.mu.Unlock()
+
+	now := time.Now()
+	for key, amounts := range this.pending {
+		for _, v := range amounts {
+			this.caps.sent[key] = append(this.caps.sent[key], valueSent{at: now, amount: v})
+		}
+	}
+	this.pending = make(map[string][]*big.Int)
+}
+
+// capOf returns the cap of asset key, nil if it has none
+func (this *ValueCaps) capOf(key string) *valueCap {
+	if c, ok := this.caps[key]; ok {
+		return c
+	}
+	return this.caps["*"]
+}
+
+// inWindow drops the transfers of asset key older than window and returns the
+// total of those left
+func (this *ValueCaps) inWindow(key string, window time.Duration, now time.Time) *big.Int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	sent := this.sent[key]
+	for len(sent) > 0 && now.Sub(sent[0].at) >= window {
+		sent = sent[1:]
+	}
+	this.sent[key] = sent
+	total := new(big.Int)
+	for _, v := range sent {
+		total.Add(total, v.amount)
+	}
+	return total
+}
+
+// transferHeld counts and alerts about a transfer stored in db.BKTHeld
+func transferHeld(letter *db.DeadLetter) {
+	metrics.Add(METRIC_VALUE_CAP_HELD, 1)
+	metrics.Alert(ALERT_TRANSFER_HELD, "%s:%s held for approval: %s", letter.Direction, letter.ID, letter.Reason)
+}
+
+// ApproveHeld puts held transfer direction:id back to be relayed, past the caps
+func ApproveHeld(boltDB *db.BoltDB, direction string, id string) error {
+	letter, err := boltDB.GetHeld(direction, id)
+	if err != nil {
+		return err
+	}
+	if letter == nil {
+		return fmt.Errorf("no held transfer %s:%s", direction, id)
+	}
+	if err = restoreTransfer(letter, boltDB.ReleaseHeld); err != nil {
+		return err
+	}
+	log.Infof("ApproveHeld - %s:%s approved, held at %s for: %s",
+		letter.Direction, letter.ID, time.Unix(letter.Time, 0).UTC().Format(time.RFC3339), letter.Reason)
+	return nil
+}
+
+// DropHeld moves held transfer direction:id to the dead letters
+func DropHeld(boltDB *db.BoltDB, direction string, id string) error {
+	letter, err := boltDB.GetHeld(direction, id)
+	if err != nil {
+		return err
+	}
+	if letter == nil {
+		return fmt.Errorf("no held transfer %s:%s", direction, id)
+	}
+	if err = boltDB.DropHeld(letter, "dropped while held: "+letter.Reason); err != nil {
+		return err
+	}
+	metrics.Add(METRIC_DEAD_LETTERS, 1)
+	log.Infof("DropHeld - %s:%s moved to the dead letters", letter.Direction, letter.ID)
+	return nil
+}
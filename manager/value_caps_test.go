@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manager
+
+import (
+	"math/big"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/polynetwork/poly/common"
+	"github.com/polynetwork/polygon-relayer/config"
+	"github.com/polynetwork/polygon-relayer/db"
+)
+
+func TestHoldCrossTransfersOfOneTx(t *testing.T) {
+	boltDB := newTestDB(t)
+	txId := ethcommon.HexToHash("0x5e3f").Bytes()
+	var letters []*db.DeadLetter
+	for _, index := range []string{"0a", "0b"} {
+		crosstx := &CrossTransfer{txIndex: index, txId: txId, value: []byte{1}, toChain: 2, height: 100}
+		sink := common.NewZeroCopySink(nil)
+		crosstx.Serialization(sink)
+		letters = append(letters, crossTransferLetter(crosstx, sink.Bytes(), "over cap"))
+	}
+	if err := boltDB.CommitBorBlock(nil, nil, letters); err != nil {
+		t.Fatal(err)
+	}
+
+	held, err := boltDB.GetAllHeld()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(held) != 2 {
+		t.Fatalf("got %d held transfers, want one per transfer of the tx", len(held))
+	}
+	if held[0].ID == held[1].ID {
+		t.Fatalf("transfers of one tx share id %s", held[0].ID)
+	}
+}
+
+func lockProxyArgs(asset ethcommon.Address, amount int64) []byte {
+	le := make([]byte, 32)
+	b := big.NewInt(amount).Bytes()
+	for i := range b {
+		le[i] = b[len(b)-1-i]
+	}
+	sink := common.NewZeroCopySink(nil)
+	sink.WriteVarBytes(asset.Bytes())
+	sink.WriteVarBytes(ethcommon.HexToAddress("0x01").Bytes())
+	sink.WriteBytes(le)
+	return sink.Bytes()
+}
+
+func TestValueCapsBatchRecordsOnCommit(t *testing.T) {
+	proxy := ethcommon.HexToAddress("0x28ff66a1b95d7cacf8eded2e658f768f44841212")
+	asset := ethcommon.HexToAddress("0x2791bca1f2de4661ed88a30c99a7a9449aa84174")
+	caps := NewValueCaps(&config.ValueCapsConfig{
+		LockProxies: []string{proxy.Hex()},
+		Assets: map[string]*config.AssetCap{
+			"17:" + asset.Hex(): {MaxPerTx: "60", MaxPerWindow: "100", Window: 3600},
+		},
+	})
+	block := []struct {
+		amount int64
+		held   bool
+	}{{50, false}, {40, false}, {20, true}, {70, true}, {10, false}}
+
+	// a block that fails to be stored is checked again the same way
+	for i := 0; i < 2; i++ {
+		batch := caps.Batch()
+		for j, v := range block {
+			if held, reason := batch.Check(proxy, 17, lockProxyArgs(asset, v.amount)); held != v.held {
+				t.Fatalf("try %d, transfer %d: held %v (%s), want %v", i, j, held, reason, v.held)
+			}
+		}
+	}
+
+	batch := caps.Batch()
+	for _, v := range block {
+		batch.Check(proxy, 17, lockProxyArgs(asset, v.amount))
+	}
+	batch.Commit()
+	if held, _ := caps.Batch().Check(proxy, 17, lockProxyArgs(asset, 1)); !held {
+		t.Fatal("committed transfers not counted against the window")
+	}
+	if held, _ := caps.Batch().Check(proxy, 17, []byte{1}); !held {
+		t.Fatal("undecodable args not held")
+	}
+	if held, _ := caps.Batch().Check(asset, 17, []byte{1}); held {
+		t.Fatal("transfer of another contract held")
+	}
+}
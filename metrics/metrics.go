@@ -0,0 +1,250 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package metrics keeps the relayer counters, alerts and component statuses.
+// Counters and alerts are published by expvar under "relayer" and "alerts",
+// served at /debug/vars of the admin address, statuses and alerts at /status.
+// Both are read-only, the admin handlers registered with Handle need the
+// admin token to change anything.
+package metrics
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polynetwork/polygon-relayer/log"
+)
+
+var (
+	relayer = expvar.NewMap("relayer")
+
+	alertsLock   sync.Mutex
+	activeAlerts = make(map[string]*AlertInfo)
+	alertWebhook string
+
+	statusLock sync.Mutex
+	statuses   = make(map[string]interface{})
+
+	admin = http.NewServeMux() // handlers of Handle
+)
+
+func init() {
+	expvar.Publish("alerts", expvar.Func(func() interface{} {
+		return Alerts()
+	}))
+}
+
+// Add adds delta to counter name
+func Add(name string, delta int64) {
+	relayer.Add(name, delta)
+}
+
+// Set sets gauge name
+func Set(name string, value int64) {
+	v, ok := relayer.Get(name).(*expvar.Int)
+	if !ok {
+		v = new(expvar.Int)
+		relayer.Set(name, v)
+	}
+	v.Set(value)
+}
+
+// Get returns the value of counter or gauge name, 0 if it was never set
+func Get(name string) int64 {
+	if v, ok := relayer.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+type AlertInfo struct {
+	Name    string
+	Message string
+	Since   time.Time
+	Count   int
+}
+
+// SetAlertWebhook makes Alert post every alert raised as json to url
+func SetAlertWebhook(url string) {
+	alertsLock.Lock()
+	defer alertsLock.Unlock()
+
+	alertWebhook = url
+}
+
+// Alert raises alert name until Resolve(name) is called. Raising an active
+// alert again updates its message.
+func Alert(name string, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	log.Errorf("ALERT %s - %s", name, msg)
+	relayer.Add("alerts."+name, 1)
+
+	alertsLock.Lock()
+	alert, ok := activeAlerts[name]
+	if !ok {
+		alert = &AlertInfo{Name: name, Since: time.Now()}
+		activeAlerts[name] = alert
+	}
+	alert.Message = msg
+	alert.Count++
+	info := *alert
+	webhook := alertWebhook
+	alertsLock.Unlock()
+
+	if webhook != "" {
+		go postAlert(webhook, &info)
+	}
+}
+
+// Resolve clears alert name
+func Resolve(name string) {
+	alertsLock.Lock()
+	defer alertsLock.Unlock()
+
+	if alert, ok := activeAlerts[name]; ok {
+		log.Infof("Resolve - alert %s resolved after %s", name, time.Since(alert.Since).Round(time.Second))
+		delete(activeAlerts, name)
+	}
+}
+
+// Alerts returns the active alerts
+func Alerts() []AlertInfo {
+	alertsLock.Lock()
+	defer alertsLock.Unlock()
+
+	res := make([]AlertInfo, 0, len(activeAlerts))
+	for _, v := range activeAlerts {
+		res = append(res, *v)
+	}
+	return res
+}
+
+func postAlert(url string, alert *AlertInfo) {
+	raw, err := json.Marshal(alert)
+	if err != nil {
+		log.Errorf("postAlert - marshal alert %s error: %s", alert.Name, err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(raw))
+	if err != nil {
+		log.Errorf("postAlert - post alert %s error: %s", alert.Name, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Errorf("postAlert - post alert %s, webhook status: %s", alert.Name, resp.Status)
+	}
+}
+
+// SetStatus publishes the status of component at /status, status must
+// marshal to json and must not be changed afterwards
+func SetStatus(component string, status interface{}) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	statuses[component] = status
+}
+
+// Statuses returns the status of every component
+func Statuses() map[string]interface{} {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	res := make(map[string]interface{}, len(statuses))
+	for k, v := range statuses {
+		res[k] = v
+	}
+	return res
+}
+
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	raw, err := json.MarshalIndent(map[string]interface{}{
+		"status": Statuses(),
+		"alerts": Alerts(),
+	}, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(raw)
+}
+
+// Handle serves handler at pattern of the admin address. Requests other than
+// GET and HEAD need the admin token.
+func Handle(pattern string, handler http.Handler) {
+	admin.Handle(pattern, handler)
+}
+
+// Serve serves expvar at http://addr/debug/vars, the statuses at
+// http://addr/status and the handlers of Handle. Writes to the handlers need
+// "Authorization: Bearer <token>" and are refused if token is empty. It
+// blocks until the server fails.
+func Serve(addr string, token string) error {
+	log.Infof("Serve - admin server listening on %s", addr)
+	return http.ListenAndServe(addr, newHandler(token))
+}
+
+func newHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", readOnly(expvar.Handler()))
+	mux.Handle("/status", readOnly(http.HandlerFunc(serveStatus)))
+	mux.Handle("/", authorize(token, admin))
+	return mux
+}
+
+func isRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+func readOnly(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func authorize(token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isRead(r) {
+			if token == "" {
+				http.Error(w, "writes are disabled, AdminToken is not set", http.StatusForbidden)
+				return
+			}
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+				log.Warnf("authorize - refused %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	Handle("/test_admin", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method))
+	}))
+	const token = "0123456789abcdef"
+
+	for _, c := range []struct {
+		name   string
+		token  string // of the server
+		method string
+		path   string
+		auth   string
+		want   int
+	}{
+		{"vars", token, http.MethodGet, "/debug/vars", "", http.StatusOK},
+		{"status", token, http.MethodGet, "/status", "", http.StatusOK},
+		{"vars write", token, http.MethodPut, "/debug/vars", "Bearer " + token, http.StatusMethodNotAllowed},
+		{"status write", token, http.MethodPost, "/status", "Bearer " + token, http.StatusMethodNotAllowed},
+		{"admin read", token, http.MethodGet, "/test_admin", "", http.StatusOK},
+		{"admin write", token, http.MethodPut, "/test_admin", "Bearer " + token, http.StatusOK},
+		{"admin write without token", token, http.MethodPut, "/test_admin", "", http.StatusUnauthorized},
+		{"admin write with wrong token", token, http.MethodPut, "/test_admin", "Bearer 0123456789abcdeX", http.StatusUnauthorized},
+		{"admin write with raw token", token, http.MethodPut, "/test_admin", token, http.StatusUnauthorized},
+		{"admin write, no token set", "", http.MethodPut, "/test_admin", "Bearer ", http.StatusForbidden},
+		{"admin read, no token set", "", http.MethodGet, "/test_admin", "", http.StatusOK},
+		{"not found", token, http.MethodGet, "/nothing", "", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(c.method, c.path, strings.NewReader("{}"))
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		newHandler(c.token).ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("%s: %s %s got status %d, want %d", c.name, c.method, c.path, w.Code, c.want)
+		}
+	}
+
+	// nothing is served by the default mux
+	r := httptest.NewRequest(http.MethodPut, "/test_admin", nil)
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("admin handler on the default mux, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package poly_go_sdk
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ontio/ontology-crypto/keypair"
+	"github.com/ontio/ontology-crypto/signature"
+	poly_go_sdk "github.com/polynetwork/poly-go-sdk"
+	"github.com/polynetwork/poly/common"
+	"github.com/polynetwork/poly/core/types"
+	"github.com/polynetwork/polygon-relayer/tools"
+)
+
+// content type sent to the remote signer's account_signData
+const PolyTxContentType = "application/x-poly-transaction"
+
+// Signer signs poly transactions, either with an account of the local wallet
+// or with a remote signer.
+type Signer interface {
+	poly_go_sdk.Signer
+	GetAddress() common.Address
+}
+
+// AccountSigner is a Signer backed by an account of the local wallet
+type AccountSigner struct {
+	*poly_go_sdk.Account
+}
+
+func NewAccountSigner(acc *poly_go_sdk.Account) *AccountSigner {
+	return &AccountSigner{Account: acc}
+}
+
+func (this *AccountSigner) GetAddress() common.Address {
+	return this.Address
+}
+
+// RemoteSigner asks a remote signer to sign poly transactions through clef
+// style account_signData calls. The signer must return the serialized
+// ontology-crypto signature of the data.
+type RemoteSigner struct {
+	url        string
+	pubKey     keypair.PublicKey
+	address    common.Address
+	restClient *tools.RestClient
+}
+
+// NewRemoteSigner creates a signer for the account of the hex serialized
+// public key pubKeyHex, held by the remote signer at url.
+func NewRemoteSigner(url string, pubKeyHex string) (*RemoteSigner, error) {
+	raw, err := hex.DecodeString(pubKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("NewRemoteSigner - decode public key error: %v", err)
+	}
+	pubKey, err := keypair.DeserializePublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("NewRemoteSigner - deserialize public key error: %v", err)
+	}
+	return &RemoteSigner{
+		url:        url,
+		pubKey:     pubKey,
+		address:    types.AddressFromPubKey(pubKey),
+		restClient: tools.NewRestClient(),
+	}, nil
+}
+
+func (this *RemoteSigner) Sign(data []byte) ([]byte, error) {
+	var sig hexutil.Bytes
+	err := tools.SendRpcRequest(this.url, this.restClient, "account_signData",
+		[]interface{}{PolyTxContentType, this.address.ToBase58(), hexutil.Bytes(data)}, &sig)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := signature.Deserialize(sig)
+	if err != nil {
+		return nil, fmt.Errorf("RemoteSigner.Sign - invalid signature from remote signer: %v", err)
+	}
+	if !signature.Verify(this.pubKey, data, parsed) {
+		return nil, fmt.Errorf("RemoteSigner.Sign - signature from remote signer does not match public key of %s", this.address.ToBase58())
+	}
+	return sig, nil
+}
+
+func (this *RemoteSigner) GetPublicKey() keypair.PublicKey {
+	return this.pubKey
+}
+
+// GetPrivateKey returns nil, the private key never leaves the remote signer
+func (this *RemoteSigner) GetPrivateKey() keypair.PrivateKey {
+	return nil
+}
+
+func (this *RemoteSigner) GetSigScheme() signature.SignatureScheme {
+	return signature.SHA256withECDSA
+}
+
+func (this *RemoteSigner) GetAddress() common.Address {
+	return this.address
+}
+
+func (this *PolySdk) SyncBlockHeader(chainId uint64, headers [][]byte, signer Signer) (common.Uint256, error) {
+	tx, err := this.Native.Hs.NewSyncBlockHeaderTransaction(chainId, signer.GetAddress(), headers)
+	if err != nil {
+		return common.UINT256_EMPTY, err
+	}
+	if err = this.SignToTransaction(tx, signer); err != nil {
+		return common.UINT256_EMPTY, err
+	}
+	return this.SendTransaction(tx)
+}
+
+func (this *PolySdk) ImportOuterTransfer(sourceChainId uint64, txData []byte, height uint32, proof []byte,
+	relayerAddress []byte, HeaderOrCrossChainMsg []byte, signer Signer) (common.Uint256, error) {
+	tx, err := this.Native.Ccm.NewImportOuterTransferTransaction(sourceChainId, txData, height, proof, relayerAddress, HeaderOrCrossChainMsg)
+	if err != nil {
+		return common.UINT256_EMPTY, err
+	}
+	if err = this.SignToTransaction(tx, signer); err != nil {
+		return common.UINT256_EMPTY, err
+	}
+	return this.SendTransaction(tx)
+}
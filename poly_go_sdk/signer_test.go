@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package poly_go_sdk
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ontio/ontology-crypto/keypair"
+	"github.com/ontio/ontology-crypto/signature"
+)
+
+// fakeSigner answers account_signData like a remote signer, sign returns the
+// signature of data
+type fakeSigner struct {
+	sign func(data []byte) []byte
+}
+
+func (this *fakeSigner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	req := &struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+		Id     uint              `json:"id"`
+	}{}
+	if err := json.Unmarshal(body, req); err != nil || req.Method != "account_signData" || len(req.Params) != 3 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var data hexutil.Bytes
+	if err := json.Unmarshal(req.Params[2], &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	raw, _ := json.Marshal(hexutil.Bytes(this.sign(data)))
+	json.NewEncoder(w).Encode(&struct {
+		JsonRpc string          `json:"jsonrpc"`
+		Result  json.RawMessage `json:"result"`
+		Id      uint            `json:"id"`
+	}{"2.0", raw, req.Id})
+}
+
+func signWith(t *testing.T, key keypair.PrivateKey, data []byte) []byte {
+	sig, err := signature.Sign(signature.SHA256withECDSA, key, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := signature.Serialize(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestRemoteSignerSign(t *testing.T) {
+	key, pub, err := keypair.GenerateKeyPair(keypair.PK_ECDSA, keypair.P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := keypair.GenerateKeyPair(keypair.PK_ECDSA, keypair.P256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("poly transaction")
+
+	for _, c := range []struct {
+		name string
+		sign func(data []byte) []byte
+		err  string
+	}{
+		{"signed as asked", func(data []byte) []byte { return signWith(t, key, data) }, ""},
+		{"other key", func(data []byte) []byte { return signWith(t, other, data) }, "does not match"},
+		{"other data", func(data []byte) []byte { return signWith(t, key, append(data, 1)) }, "does not match"},
+		{"empty", func(data []byte) []byte { return []byte{} }, "invalid signature"},
+	} {
+		server := httptest.NewServer(&fakeSigner{sign: c.sign})
+		signer, err := NewRemoteSigner(server.URL, hex.EncodeToString(keypair.SerializePublicKey(pub)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := signer.Sign(data)
+		server.Close()
+		if c.err == "" {
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			parsed, err := signature.Deserialize(sig)
+			if err != nil || !signature.Verify(pub, data, parsed) {
+				t.Fatalf("%s: signature does not verify", c.name)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s: got error %v, want %q", c.name, err, c.err)
+		}
+	}
+}
@@ -62,6 +62,13 @@ func (this *EthKeyStore) UnlockKeys(sigConfig *config.ETHConfig) error {
 	return nil
 }
 
+func (this *EthKeyStore) UnlockAccount(acc accounts.Account, pwd string) error {
+	if err := this.ks.Unlock(acc, pwd); err != nil {
+		return fmt.Errorf("failed to unlock eth acc %s: %v", acc.Address.String(), err)
+	}
+	return nil
+}
+
 func (this *EthKeyStore) SignTransaction(tx *types.Transaction, acc accounts.Account) (*types.Transaction, error) {
 	tx, err := this.ks.SignTx(acc, tx, this.chainId)
 	if err != nil {
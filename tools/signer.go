@@ -0,0 +1,239 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+package tools
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/polynetwork/polygon-relayer/log"
+)
+
+// EthSigner signs bor transactions for the relayer accounts. EthKeyStore,
+// RemoteEthSigner and LocalEthSigner implement it.
+type EthSigner interface {
+	GetAccounts() []accounts.Account
+	SignTransaction(tx *types.Transaction, acc accounts.Account) (*types.Transaction, error)
+	GetChainId() uint64
+}
+
+type rpcReq struct {
+	JsonRpc string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+	Id      uint          `json:"id"`
+}
+
+type rpcRsp struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonError      `json:"error,omitempty"`
+	Id      uint            `json:"id"`
+}
+
+// SendRpcRequest posts a json-rpc request to url and decodes its result into result
+func SendRpcRequest(url string, restClient *RestClient, method string, params []interface{}, result interface{}) error {
+	reqData, err := json.Marshal(&rpcReq{
+		JsonRpc: "2.0",
+		Method:  method,
+		Params:  params,
+		Id:      1,
+	})
+	if err != nil {
+		return fmt.Errorf("SendRpcRequest: marshal %s req err: %s", method, err)
+	}
+	rspData, err := restClient.SendRestRequest(url, reqData)
+	if err != nil {
+		return fmt.Errorf("SendRpcRequest: %s err: %s", method, err)
+	}
+	rsp := &rpcRsp{}
+	if err = json.Unmarshal(rspData, rsp); err != nil {
+		return fmt.Errorf("SendRpcRequest: unmarshal %s resp err: %s", method, err)
+	}
+	if rsp.Error != nil {
+		return fmt.Errorf("SendRpcRequest: %s resp err: %s", method, rsp.Error.Message)
+	}
+	if err = json.Unmarshal(rsp.Result, result); err != nil {
+		return fmt.Errorf("SendRpcRequest: unmarshal %s result err: %s", method, err)
+	}
+	return nil
+}
+
+// RemoteEthSigner signs with an external signer speaking clef's json-rpc api,
+// so the relayer does not need to hold the bor private keys.
+type RemoteEthSigner struct {
+	url        string
+	chainId    *big.Int
+	accs       []accounts.Account
+	restClient *RestClient
+}
+
+type signTxArgs struct {
+	From     common.MixedcaseAddress  `json:"from"`
+	To       *common.MixedcaseAddress `json:"to"`
+	Gas      hexutil.Uint64           `json:"gas"`
+	GasPrice hexutil.Big              `json:"gasPrice"`
+	Value    hexutil.Big              `json:"value"`
+	Nonce    hexutil.Uint64           `json:"nonce"`
+	Data     hexutil.Bytes            `json:"data"`
+	ChainID  *hexutil.Big             `json:"chainId,omitempty"`
+}
+
+type signTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+func NewRemoteEthSigner(url string, chainId *big.Int) (*RemoteEthSigner, error) {
+	signer := &RemoteEthSigner{
+		url:        url,
+		chainId:    chainId,
+		restClient: NewRestClient(),
+	}
+	var addrs []common.Address
+	if err := SendRpcRequest(url, signer.restClient, "account_list", []interface{}{}, &addrs); err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("remote signer %s has no account", url)
+	}
+	str := ""
+	for i, v := range addrs {
+		signer.accs = append(signer.accs, accounts.Account{Address: v})
+		str += fmt.Sprintf("(no.%d acc: %s), ", i+1, v.String())
+	}
+	log.Infof("relayer are using remote accounts: [ %s ]", str)
+	return signer, nil
+}
+
+func (this *RemoteEthSigner) SignTransaction(tx *types.Transaction, acc accounts.Account) (*types.Transaction, error) {
+	args := &signTxArgs{
+		From:     common.NewMixedcaseAddress(acc.Address),
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: hexutil.Big(*tx.GasPrice()),
+		Value:    hexutil.Big(*tx.Value()),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		Data:     tx.Data(),
+		ChainID:  (*hexutil.Big)(this.chainId),
+	}
+	if tx.To() != nil {
+		to := common.NewMixedcaseAddress(*tx.To())
+		args.To = &to
+	}
+	res := &signTxResult{}
+	if err := SendRpcRequest(this.url, this.restClient, "account_signTransaction", []interface{}{args}, res); err != nil {
+		return nil, err
+	}
+	signedTx := new(types.Transaction)
+	if err := rlp.DecodeBytes(res.Raw, signedTx); err != nil {
+		return nil, fmt.Errorf("failed to decode tx signed by remote signer: %v", err)
+	}
+	if err := this.checkSigned(tx, signedTx, acc); err != nil {
+		return nil, fmt.Errorf("remote signer returned a bad tx: %s", err)
+	}
+	return signedTx, nil
+}
+
+// checkSigned makes sure the remote signer signed tx as asked, for chainId and
+// with the key of acc
+func (this *RemoteEthSigner) checkSigned(tx *types.Transaction, signedTx *types.Transaction, acc accounts.Account) error {
+	if signedTx.Hash() == tx.Hash() {
+		return fmt.Errorf("tx is not signed")
+	}
+	if signedTx.ChainId().Cmp(this.chainId) != 0 {
+		return fmt.Errorf("chain id %s, expect %s", signedTx.ChainId(), this.chainId)
+	}
+	sender, err := types.Sender(types.NewEIP155Signer(this.chainId), signedTx)
+	if err != nil {
+		return fmt.Errorf("recover sender: %s", err)
+	}
+	if sender != acc.Address {
+		return fmt.Errorf("signed by %s, expect %s", sender.String(), acc.Address.String())
+	}
+	if signedTx.Nonce() != tx.Nonce() {
+		return fmt.Errorf("nonce %d, expect %d", signedTx.Nonce(), tx.Nonce())
+	}
+	if (signedTx.To() == nil) != (tx.To() == nil) || (tx.To() != nil && *signedTx.To() != *tx.To()) {
+		return fmt.Errorf("to %v, expect %v", signedTx.To(), tx.To())
+	}
+	if signedTx.Value().Cmp(tx.Value()) != 0 {
+		return fmt.Errorf("value %s, expect %s", signedTx.Value(), tx.Value())
+	}
+	if signedTx.Gas() != tx.Gas() {
+		return fmt.Errorf("gas %d, expect %d", signedTx.Gas(), tx.Gas())
+	}
+	if signedTx.GasPrice().Cmp(tx.GasPrice()) != 0 {
+		return fmt.Errorf("gas price %s, expect %s", signedTx.GasPrice(), tx.GasPrice())
+	}
+	if !bytes.Equal(signedTx.Data(), tx.Data()) {
+		return fmt.Errorf("data differs")
+	}
+	return nil
+}
+
+func (this *RemoteEthSigner) GetAccounts() []accounts.Account {
+	return this.accs
+}
+
+func (this *RemoteEthSigner) GetChainId() uint64 {
+	return this.chainId.Uint64()
+}
+
+// LocalEthSigner keeps private keys in memory. It is a stand-in for the
+// keystore or a remote signer in tests.
+type LocalEthSigner struct {
+	keys    map[common.Address]*ecdsa.PrivateKey
+	accs    []accounts.Account
+	chainId *big.Int
+}
+
+func NewLocalEthSigner(chainId *big.Int, keys ...*ecdsa.PrivateKey) *LocalEthSigner {
+	signer := &LocalEthSigner{
+		keys:    make(map[common.Address]*ecdsa.PrivateKey),
+		chainId: chainId,
+	}
+	for _, key := range keys {
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		signer.keys[addr] = key
+		signer.accs = append(signer.accs, accounts.Account{Address: addr})
+	}
+	return signer
+}
+
+func (this *LocalEthSigner) SignTransaction(tx *types.Transaction, acc accounts.Account) (*types.Transaction, error) {
+	key, ok := this.keys[acc.Address]
+	if !ok {
+		return nil, fmt.Errorf("no key for account %s", acc.Address.String())
+	}
+	return types.SignTx(tx, types.NewEIP155Signer(this.chainId), key)
+}
+
+func (this *LocalEthSigner) GetAccounts() []accounts.Account {
+	return this.accs
+}
+
+func (this *LocalEthSigner) GetChainId() uint64 {
+	return this.chainId.Uint64()
+}
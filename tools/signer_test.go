@@ -0,0 +1,200 @@
+/*
+* Copyright (C) 2020 The poly network Authors
+* This file is part of The poly network library.
+*
+* The poly network is free software: you can redistribute it and/or modify
+* it under the terms of the GNU Lesser General Public License as published by
+* the Free Software Foundation, either version 3 of the License, or
+* (at your option) any later version.
+*
+* The poly network is distributed in the hope that it will be useful,
+* but WITHOUT ANY WARRANTY; without even the implied warranty of
+* MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+* GNU Lesser General Public License for more details.
+* You should have received a copy of the GNU Lesser General Public License
+* along with The poly network . If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// fakeClef answers account_list and account_signTransaction like clef, tamper
+// changes the tx it signs
+type fakeClef struct {
+	key    *ecdsa.PrivateKey
+	tamper func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction
+}
+
+func (this *fakeClef) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	req := &struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+		Id     uint              `json:"id"`
+	}{}
+	if err := json.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var result interface{}
+	switch req.Method {
+	case "account_list":
+		result = []common.Address{crypto.PubkeyToAddress(this.key.PublicKey)}
+	case "account_signTransaction":
+		args := &signTxArgs{}
+		if err := json.Unmarshal(req.Params[0], args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key, chainId := this.key, (*big.Int)(args.ChainID)
+		var tx *types.Transaction
+		if this.tamper != nil {
+			tx = this.tamper(args, &key, &chainId)
+		}
+		if tx == nil {
+			tx = types.NewTransaction(uint64(args.Nonce), args.To.Address(), (*big.Int)(&args.Value), uint64(args.Gas),
+				(*big.Int)(&args.GasPrice), args.Data)
+			tx, _ = types.SignTx(tx, types.NewEIP155Signer(chainId), key)
+		}
+		raw, _ := rlp.EncodeToBytes(tx)
+		result = &signTxResult{Raw: raw}
+	default:
+		http.Error(w, "unknown method", http.StatusBadRequest)
+		return
+	}
+	raw, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(&rpcRsp{JsonRpc: "2.0", Result: raw, Id: req.Id})
+}
+
+func TestRemoteEthSignerSignTransaction(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	chainId := big.NewInt(137)
+	to := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+	cases := []struct {
+		name   string
+		tamper func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction
+		err    string
+	}{
+		{name: "signed as asked"},
+		{
+			name: "unsigned",
+			tamper: func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction {
+				return types.NewTransaction(uint64(args.Nonce), args.To.Address(), (*big.Int)(&args.Value), uint64(args.Gas),
+					(*big.Int)(&args.GasPrice), args.Data)
+			},
+			err: "not signed",
+		},
+		{
+			name: "other key",
+			tamper: func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction {
+				*key = other
+				return nil
+			},
+			err: "signed by",
+		},
+		{
+			name: "other chain",
+			tamper: func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction {
+				*chainId = big.NewInt(1)
+				return nil
+			},
+			err: "chain id",
+		},
+		{
+			name: "other nonce",
+			tamper: func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction {
+				args.Nonce++
+				return nil
+			},
+			err: "nonce",
+		},
+		{
+			name: "other to",
+			tamper: func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction {
+				bad := common.NewMixedcaseAddress(common.HexToAddress("0xbb"))
+				args.To = &bad
+				return nil
+			},
+			err: "to ",
+		},
+		{
+			name: "other value",
+			tamper: func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction {
+				args.Value = hexutil.Big(*big.NewInt(1))
+				return nil
+			},
+			err: "value",
+		},
+		{
+			name: "other gas",
+			tamper: func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction {
+				args.Gas++
+				return nil
+			},
+			err: "gas",
+		},
+		{
+			name: "other gas price",
+			tamper: func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction {
+				args.GasPrice = hexutil.Big(*big.NewInt(2))
+				return nil
+			},
+			err: "gas price",
+		},
+		{
+			name: "other data",
+			tamper: func(args *signTxArgs, key **ecdsa.PrivateKey, chainId **big.Int) *types.Transaction {
+				args.Data = []byte{0xff}
+				return nil
+			},
+			err: "data",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(&fakeClef{key: key, tamper: c.tamper})
+			defer server.Close()
+
+			signer, err := NewRemoteEthSigner(server.URL, chainId)
+			if err != nil {
+				t.Fatalf("NewRemoteEthSigner: %s", err)
+			}
+			acc := signer.GetAccounts()[0]
+			if acc.Address != crypto.PubkeyToAddress(key.PublicKey) {
+				t.Fatalf("account %s, expect %s", acc.Address.String(), crypto.PubkeyToAddress(key.PublicKey).String())
+			}
+			tx := types.NewTransaction(7, to, big.NewInt(0), 21000, big.NewInt(30e9), []byte{1, 2, 3})
+			signed, err := signer.SignTransaction(tx, acc)
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("SignTransaction: %s", err)
+				}
+				if sender, _ := types.Sender(types.NewEIP155Signer(chainId), signed); sender != acc.Address {
+					t.Fatalf("signed by %s", sender.String())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("err %v, expect %q", err, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/polynetwork/poly/common"
+)
+
+// LOCK_PROXY_AMOUNT_LEN is the length of the little-endian uint255 amount
+const LOCK_PROXY_AMOUNT_LEN = 32
+
+// ErrInvalidLockProxyArgs is wrapped by the errors of malformed lock proxy args
+var ErrInvalidLockProxyArgs = errors.New("invalid lock proxy args")
+
+// LockProxyArgs are the args of a lock proxy unlock, the MakeTxParam.Args of
+// a lock proxy transfer: varBytes toAsset, varBytes toAddress, uint255 amount
+type LockProxyArgs struct {
+	ToAsset   []byte // asset on the target chain
+	ToAddress []byte
+	Amount    *big.Int
+}
+
+// DecodeLockProxyArgs decodes lock proxy args, the error wraps
+// ErrInvalidLockProxyArgs if they do not have the expected shape
+func DecodeLockProxyArgs(args []byte) (*LockProxyArgs, error) {
+	source := common.NewZeroCopySource(args)
+	toAsset, eof := source.NextVarBytes()
+	if eof {
+		return nil, fmt.Errorf("toAsset: %w", ErrInvalidLockProxyArgs)
+	}
+	toAddress, eof := source.NextVarBytes()
+	if eof {
+		return nil, fmt.Errorf("toAddress: %w", ErrInvalidLockProxyArgs)
+	}
+	raw, eof := source.NextBytes(LOCK_PROXY_AMOUNT_LEN)
+	if eof {
+		return nil, fmt.Errorf("amount: %w", ErrInvalidLockProxyArgs)
+	}
+	if source.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes after amount: %w", source.Len(), ErrInvalidLockProxyArgs)
+	}
+	be := make([]byte, LOCK_PROXY_AMOUNT_LEN)
+	for i, b := range raw {
+		be[LOCK_PROXY_AMOUNT_LEN-1-i] = b
+	}
+	amount := new(big.Int).SetBytes(be)
+	if amount.BitLen() > 255 {
+		return nil, fmt.Errorf("amount over 255 bits: %w", ErrInvalidLockProxyArgs)
+	}
+	return &LockProxyArgs{ToAsset: toAsset, ToAddress: toAddress, Amount: amount}, nil
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package types
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const NOTIFY_MAKE_PROOF = "makeProof"
+
+// ErrInvalidNotify is wrapped by the errors of malformed poly notifies
+var ErrInvalidNotify = errors.New("invalid poly notify")
+
+// MakeProofNotify is the notify of the poly cross chain manager for a
+// transfer it made a proof of, states:
+// ["makeProof", fromChainID, toChainID, txHash, height, key]
+type MakeProofNotify struct {
+	FromChainID uint64
+	ToChainID   uint64
+	TxHash      string // hex
+	Height      uint32 // poly height of the proof
+	Key         string // hex storage key of the proof
+}
+
+// NotifyMethod returns the method of a notify, the first of its states, or
+// "" if the notify has no method
+func NotifyMethod(states interface{}) string {
+	list, ok := states.([]interface{})
+	if !ok || len(list) == 0 {
+		return ""
+	}
+	method, _ := list[0].(string)
+	return method
+}
+
+// DecodeMakeProofNotify decodes the states of a makeProof notify, the error
+// wraps ErrInvalidNotify if they do not have the expected shape
+func DecodeMakeProofNotify(states interface{}) (*MakeProofNotify, error) {
+	list, ok := states.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("states are %T, not a list: %w", states, ErrInvalidNotify)
+	}
+	if len(list) != 6 {
+		return nil, fmt.Errorf("makeProof notify has %d states, expect 6: %w", len(list), ErrInvalidNotify)
+	}
+	if method, _ := list[0].(string); method != NOTIFY_MAKE_PROOF {
+		return nil, fmt.Errorf("method %v is not %s: %w", list[0], NOTIFY_MAKE_PROOF, ErrInvalidNotify)
+	}
+	var (
+		notify = &MakeProofNotify{}
+		err    error
+	)
+	if notify.FromChainID, err = notifyUint(list[1], math.MaxUint64); err != nil {
+		return nil, fmt.Errorf("fromChainID: %w", err)
+	}
+	if notify.ToChainID, err = notifyUint(list[2], math.MaxUint64); err != nil {
+		return nil, fmt.Errorf("toChainID: %w", err)
+	}
+	if notify.TxHash, err = notifyHex(list[3]); err != nil {
+		return nil, fmt.Errorf("txHash: %w", err)
+	}
+	height, err := notifyUint(list[4], math.MaxUint32)
+	if err != nil {
+		return nil, fmt.Errorf("height: %w", err)
+	}
+	notify.Height = uint32(height)
+	if notify.Key, err = notifyHex(list[5]); err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	return notify, nil
+}
+
+// notifyUint accepts the numbers of a json decoded notify, float64 or
+// json.Number, as well as go integers
+func notifyUint(v interface{}, max uint64) (uint64, error) {
+	var n uint64
+	switch v := v.(type) {
+	case float64:
+		// above 2^53 a float64 does not hold every integer
+		if v < 0 || v != math.Trunc(v) || v > 1<<53 {
+			return 0, fmt.Errorf("%v is not an integer: %w", v, ErrInvalidNotify)
+		}
+		n = uint64(v)
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil || i < 0 {
+			return 0, fmt.Errorf("%s is not an integer: %w", v, ErrInvalidNotify)
+		}
+		n = uint64(i)
+	case uint64:
+		n = v
+	case uint32:
+		n = uint64(v)
+	case int64:
+		if v < 0 {
+			return 0, fmt.Errorf("%d is negative: %w", v, ErrInvalidNotify)
+		}
+		n = uint64(v)
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("%d is negative: %w", v, ErrInvalidNotify)
+		}
+		n = uint64(v)
+	default:
+		return 0, fmt.Errorf("%v is %T, not a number: %w", v, v, ErrInvalidNotify)
+	}
+	if n > max {
+		return 0, fmt.Errorf("%d is out of range: %w", n, ErrInvalidNotify)
+	}
+	return n, nil
+}
+
+func notifyHex(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%v is %T, not a string: %w", v, v, ErrInvalidNotify)
+	}
+	if s == "" {
+		return "", fmt.Errorf("empty: %w", ErrInvalidNotify)
+	}
+	if _, err := hex.DecodeString(strings.TrimPrefix(s, "0x")); err != nil {
+		return "", fmt.Errorf("%s is not hex: %w", s, ErrInvalidNotify)
+	}
+	return s, nil
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2020 The poly network Authors
+ * This file is part of The poly network library.
+ *
+ * The  poly network  is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The  poly network  is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The poly network .  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package types
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/polynetwork/poly/native/service/utils"
+)
+
+// a makeProof notify of a transfer from ethereum (2) to polygon (17), laid out
+// as MakeTransaction of the poly cross chain manager emits it: the source tx
+// hash, the poly height and the storage key of the request, that is
+// CrossChainManagerContractAddress | "request" | toChainID LE | poly tx hash
+const makeProofStates = `["makeProof", 2, 17,
+	"5c1f0e9a27d84b36ae905d7c13f2b8e4a06d9c57e1b3f28a40c7d96e5b2a1f08",
+	12876543,
+	"00000000000000000000000000000000000000037265717565737411000000000000008f3a6c2d9e41b7058c6d2fa1e39b4470c51d8e2a6b9f03d71e4c5a28b6f90d13"]`
+
+const makeProofPolyTx = "8f3a6c2d9e41b7058c6d2fa1e39b4470c51d8e2a6b9f03d71e4c5a28b6f90d13"
+
+func decodeStates(t *testing.T, s string, useNumber bool) interface{} {
+	d := json.NewDecoder(bytes.NewBufferString(s))
+	if useNumber {
+		d.UseNumber()
+	}
+	var states interface{}
+	if err := d.Decode(&states); err != nil {
+		t.Fatal(err)
+	}
+	return states
+}
+
+func TestDecodeMakeProofNotify(t *testing.T) {
+	want := MakeProofNotify{
+		FromChainID: 2,
+		ToChainID:   17,
+		TxHash:      "5c1f0e9a27d84b36ae905d7c13f2b8e4a06d9c57e1b3f28a40c7d96e5b2a1f08",
+		Height:      12876543,
+		Key:         "00000000000000000000000000000000000000037265717565737411000000000000008f3a6c2d9e41b7058c6d2fa1e39b4470c51d8e2a6b9f03d71e4c5a28b6f90d13",
+	}
+	for _, useNumber := range []bool{false, true} {
+		notify, err := DecodeMakeProofNotify(decodeStates(t, makeProofStates, useNumber))
+		if err != nil {
+			t.Fatalf("useNumber %v: %v", useNumber, err)
+		}
+		if *notify != want {
+			t.Fatalf("useNumber %v: got %+v, want %+v", useNumber, notify, want)
+		}
+	}
+
+	polyTx, _ := hex.DecodeString(makeProofPolyTx)
+	key := utils.ConcatKey(utils.CrossChainManagerContractAddress, []byte("request"), utils.GetUint64Bytes(want.ToChainID), polyTx)
+	if hex.EncodeToString(key) != want.Key {
+		t.Fatalf("key %s is not the request key of poly tx %s", want.Key, makeProofPolyTx)
+	}
+}
+
+func TestDecodeMakeProofNotifyInvalid(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		states    string
+		floatOnly bool // json.Number holds it exactly
+	}{
+		{"not a list", `{"method": "makeProof"}`, false},
+		{"short", `["makeProof", 17, 2, "d6d4", 17654321]`, false},
+		{"long", `["makeProof", 17, 2, "d6d4", 17654321, "1a2b", "1a2b"]`, false},
+		{"foreign method", `["unlock", 17, 2, "d6d4", 17654321, "1a2b"]`, false},
+		{"no method", `[17, 17, 2, "d6d4", 17654321, "1a2b"]`, false},
+		{"float above 2^53", `["makeProof", 9007199254740994, 2, "d6d4", 17654321, "1a2b"]`, true},
+		{"negative chain id", `["makeProof", -17, 2, "d6d4", 17654321, "1a2b"]`, false},
+		{"fractional chain id", `["makeProof", 17, 2.5, "d6d4", 17654321, "1a2b"]`, false},
+		{"string chain id", `["makeProof", "17", 2, "d6d4", 17654321, "1a2b"]`, false},
+		{"height above uint32", `["makeProof", 17, 2, "d6d4", 4294967296, "1a2b"]`, false},
+		{"non-hex tx hash", `["makeProof", 17, 2, "d6d4zz", 17654321, "1a2b"]`, false},
+		{"non-hex key", `["makeProof", 17, 2, "d6d4", 17654321, "0xkey"]`, false},
+		{"empty key", `["makeProof", 17, 2, "d6d4", 17654321, ""]`, false},
+		{"numeric key", `["makeProof", 17, 2, "d6d4", 17654321, 6699]`, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			for _, useNumber := range []bool{false, true} {
+				if useNumber && c.floatOnly {
+					continue
+				}
+				_, err := DecodeMakeProofNotify(decodeStates(t, c.states, useNumber))
+				if !errors.Is(err, ErrInvalidNotify) {
+					t.Fatalf("useNumber %v: got error %v, want ErrInvalidNotify", useNumber, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifyMethod(t *testing.T) {
+	for _, c := range []struct {
+		states string
+		want   string
+	}{
+		{makeProofStates, NOTIFY_MAKE_PROOF},
+		{`["unlock", 1]`, "unlock"},
+		{`[]`, ""},
+		{`[1, 2]`, ""},
+		{`"makeProof"`, ""},
+	} {
+		if got := NotifyMethod(decodeStates(t, c.states, false)); got != c.want {
+			t.Errorf("NotifyMethod(%s) = %q, want %q", c.states, got, c.want)
+		}
+	}
+}
@@ -34,6 +34,10 @@ import (
 )
 
 var ErrSpanNotFound = errors.New("span not found")
+var ErrInvalidBorHeader = errors.New("invalid bor header")
+var ErrConfirmTimeout = errors.New("poly tx not confirmed in time")
+var ErrHeadersRejected = errors.New("headers rejected by poly")
+var ErrEpochNotSynced = errors.New("poly epoch change not synced to bor")
 
 // json marshal
 type HeaderWithOptionalProof struct {